@@ -0,0 +1,451 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	slashpath "path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/butane/config/common"
+)
+
+// maximum number of symlinks followed when resolving a path
+const maxSymlinks = 40
+
+var (
+	errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+	// most recently opened archive, reused while it's unchanged
+	archiveCacheLock sync.Mutex
+	archiveCache     *archiveFS
+)
+
+// IsArchive returns true if path has the extension of a supported archive
+// format: .tar, .tar.gz, .tgz, or .zip.
+func IsArchive(path string) bool {
+	return archiveFormat(path) != ""
+}
+
+func archiveFormat(path string) string {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	default:
+		return ""
+	}
+}
+
+// OpenArchive reads a tar, gzipped tar, or zip archive into a read-only
+// filesystem, choosing the format from the file extension.  Symlinks in
+// the archive are preserved, and are followed only within the archive.
+// OpenArchive fails if an entry's path is outside the archive root.
+func OpenArchive(path string) (fs.FS, error) {
+	return openArchive(path)
+}
+
+// openArchiveCached returns the filesystem for an archive, reusing the
+// most recently opened archive if its path, size, and modification time
+// are unchanged.
+func openArchiveCached(path string, info fs.FileInfo) (*archiveFS, error) {
+	archiveCacheLock.Lock()
+	defer archiveCacheLock.Unlock()
+	if a := archiveCache; a != nil && a.path == path && a.size == info.Size() && a.modTime.Equal(info.ModTime()) {
+		return a, nil
+	}
+	a, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	a.size = info.Size()
+	a.modTime = info.ModTime()
+	archiveCache = a
+	return a, nil
+}
+
+func openArchive(path string) (*archiveFS, error) {
+	format := archiveFormat(path)
+	if format == "" {
+		return nil, fmt.Errorf("%s: unsupported archive format; must be .tar, .tar.gz, .tgz, or .zip", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &archiveFS{
+		path: path,
+		nodes: map[string]*archiveNode{
+			".": {
+				name: ".",
+				mode: fs.ModeDir | 0755,
+			},
+		},
+	}
+	switch format {
+	case "tar":
+		err = a.readTar(bytes.NewReader(data))
+	case "tar.gz":
+		var zr *gzip.Reader
+		zr, err = gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			err = a.readTar(zr)
+		}
+	case "zip":
+		err = a.readZip(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, node := range a.nodes {
+		sort.Strings(node.children)
+	}
+	return a, nil
+}
+
+// archiveFS is an in-memory filesystem read from an archive.
+type archiveFS struct {
+	// host path of the archive
+	path    string
+	size    int64
+	modTime time.Time
+	nodes   map[string]*archiveNode
+}
+
+// archiveNode is a file, directory, symlink, or other entry in an
+// archive.  It implements fs.FileInfo.
+type archiveNode struct {
+	name     string
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte
+	target   string
+	children []string
+	// first entry of a set of hard links, if any
+	inode *archiveNode
+}
+
+// archiveInode identifies the underlying file of hard-linked archive
+// entries.
+type archiveInode struct {
+	node *archiveNode
+}
+
+func (n *archiveNode) Name() string       { return n.name }
+func (n *archiveNode) Size() int64        { return int64(len(n.data)) }
+func (n *archiveNode) Mode() fs.FileMode  { return n.mode }
+func (n *archiveNode) ModTime() time.Time { return n.modTime }
+func (n *archiveNode) IsDir() bool        { return n.mode.IsDir() }
+func (n *archiveNode) Sys() interface{} {
+	if n.inode != nil {
+		return archiveInode{n.inode}
+	}
+	return nil
+}
+func (n *archiveNode) Type() fs.FileMode          { return n.mode.Type() }
+func (n *archiveNode) Info() (fs.FileInfo, error) { return n, nil }
+
+func (a *archiveFS) readTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		node := archiveNode{
+			mode:    hdr.FileInfo().Mode(),
+			modTime: hdr.ModTime,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			if node.data, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+		case tar.TypeDir:
+		case tar.TypeSymlink:
+			node.target = hdr.Linkname
+		case tar.TypeLink:
+			// hard link to an earlier entry
+			name, err := archivePath(hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			target, ok := a.nodes[name]
+			if !ok || !target.mode.IsRegular() {
+				return fmt.Errorf("archive entry %q: hard link target %q is not a regular file", hdr.Name, hdr.Linkname)
+			}
+			node.data = target.data
+			node.mode = target.mode
+			target.inode = target
+			node.inode = target
+		default:
+			// devices, FIFOs, etc.; trees will reject them
+			node.mode = node.mode.Perm() | fs.ModeIrregular
+		}
+		if err := a.add(hdr.Name, node); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *archiveFS) readZip(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		node := archiveNode{
+			mode:    f.Mode(),
+			modTime: f.Modified,
+		}
+		if !node.mode.IsDir() {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			contents, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			if node.mode&fs.ModeSymlink != 0 {
+				node.target = string(contents)
+			} else {
+				node.data = contents
+			}
+		}
+		if err := a.add(f.Name, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath converts the name of an archive entry to a path within the
+// filesystem, failing if it's outside the archive root.
+func archivePath(entryName string) (string, error) {
+	name := slashpath.Clean(strings.ReplaceAll(entryName, "\\", "/"))
+	if slashpath.IsAbs(name) || !fs.ValidPath(name) {
+		return "", fmt.Errorf("archive entry %q: %w", entryName, common.ErrFilesDirEscape)
+	}
+	return name, nil
+}
+
+// add adds an entry to the filesystem, creating any missing parent
+// directories.  A later entry with the same path replaces an earlier one.
+func (a *archiveFS) add(entryName string, node archiveNode) error {
+	name, err := archivePath(entryName)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		if node.mode.IsDir() {
+			a.nodes["."].mode = node.mode
+		}
+		return nil
+	}
+	parent := slashpath.Dir(name)
+	if err := a.mkdirAll(parent); err != nil {
+		return fmt.Errorf("archive entry %q: %w", entryName, err)
+	}
+	node.name = slashpath.Base(name)
+	if existing, ok := a.nodes[name]; ok {
+		if existing.mode.IsDir() && node.mode.IsDir() {
+			existing.mode = node.mode
+			existing.modTime = node.modTime
+			return nil
+		}
+		if existing.mode.IsDir() {
+			return fmt.Errorf("archive entry %q replaces a directory", entryName)
+		}
+	} else {
+		a.nodes[parent].children = append(a.nodes[parent].children, name)
+	}
+	a.nodes[name] = &node
+	return nil
+}
+
+func (a *archiveFS) mkdirAll(name string) error {
+	if node, ok := a.nodes[name]; ok {
+		if !node.mode.IsDir() {
+			return syscall.ENOTDIR
+		}
+		return nil
+	}
+	parent := slashpath.Dir(name)
+	if err := a.mkdirAll(parent); err != nil {
+		return err
+	}
+	a.nodes[name] = &archiveNode{
+		name: slashpath.Base(name),
+		mode: fs.ModeDir | 0755,
+	}
+	a.nodes[parent].children = append(a.nodes[parent].children, name)
+	return nil
+}
+
+// lookup returns the node at name, following symlinks in intermediate
+// path components, and in the last component if followLast is true.
+// Symlinks that lead outside the archive fail with ErrFilesDirEscape.
+func (a *archiveFS) lookup(op, name string, followLast bool) (*archiveNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	var rest []string
+	if name != "." {
+		rest = strings.Split(name, "/")
+	}
+	cur := "."
+	links := 0
+	for len(rest) > 0 {
+		next := slashpath.Join(cur, rest[0])
+		rest = rest[1:]
+		node, ok := a.nodes[next]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if node.mode&fs.ModeSymlink != 0 && (len(rest) > 0 || followLast) {
+			links++
+			if links > maxSymlinks {
+				return nil, &fs.PathError{Op: op, Path: name, Err: errTooManySymlinks}
+			}
+			target := slashpath.Join(cur, node.target)
+			if slashpath.IsAbs(node.target) || target == ".." || strings.HasPrefix(target, "../") {
+				return nil, common.ErrFilesDirEscape
+			}
+			if target != "." {
+				rest = append(strings.Split(target, "/"), rest...)
+			}
+			cur = "."
+			continue
+		}
+		if len(rest) > 0 && !node.mode.IsDir() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+		cur = next
+	}
+	return a.nodes[cur], nil
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	node, err := a.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return &archiveDir{fsys: a, node: node}, nil
+	}
+	return &archiveFile{node: node, Reader: bytes.NewReader(node.data)}, nil
+}
+
+func (a *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	return a.lookup("stat", name, true)
+}
+
+func (a *archiveFS) ReadFile(name string) ([]byte, error) {
+	node, err := a.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	return append([]byte(nil), node.data...), nil
+}
+
+func (a *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := a.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return a.entries(node), nil
+}
+
+func (a *archiveFS) ReadLink(name string) (string, error) {
+	node, err := a.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.target, nil
+}
+
+func (a *archiveFS) entries(dir *archiveNode) []fs.DirEntry {
+	ret := make([]fs.DirEntry, 0, len(dir.children))
+	for _, child := range dir.children {
+		ret = append(ret, a.nodes[child])
+	}
+	return ret
+}
+
+type archiveFile struct {
+	*bytes.Reader
+	node *archiveNode
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.node, nil }
+func (f *archiveFile) Close() error               { return nil }
+
+type archiveDir struct {
+	fsys   *archiveFS
+	node   *archiveNode
+	offset int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.node, nil }
+func (d *archiveDir) Close() error               { return nil }
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: syscall.EISDIR}
+}
+
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := d.fsys.entries(d.node)[d.offset:]
+	if n <= 0 {
+		d.offset += len(entries)
+		return entries, nil
+	}
+	if len(entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(entries) {
+		n = len(entries)
+	}
+	d.offset += n
+	return entries[:n], nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type archiveEntry struct {
+	name   string
+	mode   fs.FileMode
+	data   string
+	target string
+}
+
+func writeTar(t *testing.T, w io.Writer, entries []archiveEntry) {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := tar.Header{
+			Name: e.name,
+			Mode: int64(e.mode.Perm()),
+			Size: int64(len(e.data)),
+		}
+		switch {
+		case e.mode.IsDir():
+			hdr.Typeflag = tar.TypeDir
+		case e.mode&fs.ModeSymlink != 0:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.target
+		case e.target != "":
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = e.target
+		default:
+			hdr.Typeflag = tar.TypeReg
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeArchive writes the entries to an archive of the format implied by
+// the name.
+func makeArchive(t *testing.T, name string, entries []archiveEntry) string {
+	p := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	switch archiveFormat(name) {
+	case "tar":
+		writeTar(t, f, entries)
+	case "tar.gz":
+		zw := gzip.NewWriter(f)
+		writeTar(t, zw, entries)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case "zip":
+		zw := zip.NewWriter(f)
+		for _, e := range entries {
+			hdr := zip.FileHeader{
+				Name: e.name,
+			}
+			hdr.SetMode(e.mode)
+			if e.mode.IsDir() {
+				hdr.Name += "/"
+			}
+			w, err := zw.CreateHeader(&hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents := e.data
+			if e.mode&fs.ModeSymlink != 0 {
+				contents = e.target
+			}
+			if _, err := w.Write([]byte(contents)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func TestOpenArchive(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "./file", mode: 0644, data: "file"},
+		{name: "tree/exec", mode: 0755, data: "exec"},
+		{name: "tree/sub", mode: fs.ModeDir | 0755},
+		{name: "tree/sub/link", mode: fs.ModeSymlink | 0777, target: "../exec"},
+		{name: "tree/escape", mode: fs.ModeSymlink | 0777, target: "../../outside"},
+		{name: "tree/absolute", mode: fs.ModeSymlink | 0777, target: "/etc/shadow"},
+		{name: "dirlink", mode: fs.ModeSymlink | 0777, target: "tree/sub"},
+	}
+
+	for _, format := range []string{"tar", "tar.gz", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			fsys, err := OpenArchive(makeArchive(t, "files."+format, entries))
+			if !assert.NoError(t, err, "opening archive failed") {
+				return
+			}
+
+			for name, expected := range map[string]string{
+				"file":          "file",
+				"tree/exec":     "exec",
+				"tree/sub/link": "exec",
+				"dirlink/link":  "exec",
+			} {
+				contents, err := fs.ReadFile(fsys, name)
+				assert.NoError(t, err, "reading %s failed", name)
+				assert.Equal(t, expected, string(contents), "bad contents of %s", name)
+			}
+			for _, name := range []string{"tree/escape", "tree/absolute"} {
+				_, err := fs.ReadFile(fsys, name)
+				assert.Equal(t, common.ErrFilesDirEscape, err, "bad error reading %s", name)
+			}
+			_, err = fs.ReadFile(fsys, "missing")
+			assert.True(t, errors.Is(err, fs.ErrNotExist), "bad error reading missing file: %v", err)
+
+			info, err := fs.Stat(fsys, "tree/exec")
+			assert.NoError(t, err, "stat failed")
+			assert.Equal(t, fs.FileMode(0755), info.Mode(), "bad mode")
+
+			target, err := ReadLink(fsys, "tree/escape")
+			assert.NoError(t, err, "reading link failed")
+			assert.Equal(t, "../../outside", target, "bad link target")
+
+			var walked []string
+			err = fs.WalkDir(fsys, "tree", func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				walked = append(walked, fmt.Sprintf("%s %v", p, d.Type()))
+				return nil
+			})
+			assert.NoError(t, err, "walk failed")
+			assert.Equal(t, []string{
+				"tree d---------",
+				"tree/absolute L---------",
+				"tree/escape L---------",
+				"tree/exec ----------",
+				"tree/sub d---------",
+				"tree/sub/link L---------",
+			}, walked, "bad walk")
+		})
+	}
+}
+
+func TestOpenArchiveEscape(t *testing.T) {
+	tests := []archiveEntry{
+		{name: "../file", mode: 0644},
+		{name: "dir/../../file", mode: 0644},
+		{name: "/etc/file", mode: 0644},
+	}
+
+	for i, test := range tests {
+		for _, format := range []string{"tar", "zip"} {
+			t.Run(fmt.Sprintf("%s %d", format, i), func(t *testing.T) {
+				_, err := OpenArchive(makeArchive(t, "files."+format, []archiveEntry{test}))
+				assert.True(t, errors.Is(err, common.ErrFilesDirEscape), "bad error: %v", err)
+			})
+		}
+	}
+}
+
+func TestFilesFSArchive(t *testing.T) {
+	p := makeArchive(t, "files.tgz", []archiveEntry{
+		{name: "file", mode: 0644, data: "file"},
+		{name: "hardlink", mode: 0644, target: "file"},
+	})
+	fsys, err := FilesFS(common.TranslateOptions{
+		FilesDir: p,
+	})
+	if !assert.NoError(t, err, "opening archive failed") {
+		return
+	}
+	contents, err := fs.ReadFile(fsys, "hardlink")
+	assert.NoError(t, err, "reading hard link failed")
+	assert.Equal(t, "file", string(contents), "bad hard link contents")
+	var ids []interface{}
+	for _, name := range []string{"file", "hardlink"} {
+		info, err := fs.Stat(fsys, name)
+		if !assert.NoError(t, err, "stat of %s failed", name) {
+			return
+		}
+		id, ok := FileID(info)
+		assert.True(t, ok, "no file ID for %s", name)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, ids[0], ids[1], "hard links have different file IDs")
+	assert.Equal(t, p, RecordPath(fsys, "file"), "bad record path")
+
+	// cached until the archive changes
+	again, err := FilesFS(common.TranslateOptions{
+		FilesDir: p,
+	})
+	assert.NoError(t, err, "reopening archive failed")
+	assert.True(t, fsys == again, "archive not cached")
+
+	bad := filepath.Join(t.TempDir(), "files.txt")
+	if err := os.WriteFile(bad, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = FilesFS(common.TranslateOptions{
+		FilesDir: bad,
+	})
+	assert.Error(t, err, "opening unsupported archive succeeded")
+}
@@ -21,6 +21,8 @@ import (
 	"github.com/coreos/butane/config/common"
 )
 
+// EnsurePathWithinFilesDir fails if path is lexically outside filesDir.  It
+// doesn't resolve symlinks; use FilesFS to read files with symlink checks.
 func EnsurePathWithinFilesDir(path, filesDir string) error {
 	absBase, err := filepath.Abs(filesDir)
 	if err != nil {
@@ -49,6 +51,16 @@ func CheckForDecimalMode(mode int, directory bool) error {
 	return nil
 }
 
+/// CheckForUnusualMode fails if the specified mode is valid but would be
+/// surprising, such as a mode copied from a local file with overly
+/// restrictive or permissive permissions.
+func CheckForUnusualMode(mode int, directory bool) error {
+	if !isTypicalMode(mode, directory) {
+		return common.ErrUnusualMode
+	}
+	return nil
+}
+
 /// isTypicalMode returns true if the specified mode is unsurprising.
 /// It returns false for some modes that are unusual but valid in limited
 /// cases.
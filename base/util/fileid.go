@@ -0,0 +1,45 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"io/fs"
+)
+
+// FileID returns a comparable value identifying the underlying file of
+// info, so that hard links to the same file can be recognized.  ok is
+// false if the file has no other links or if its identity is unknown.
+func FileID(info fs.FileInfo) (id interface{}, ok bool) {
+	if inode, isArchive := info.Sys().(archiveInode); isArchive {
+		return inode, true
+	}
+	return sysFileID(info.Sys())
+}
+
+// FileModeToInt converts the permission and special mode bits of mode to
+// the integer form used in configs.
+func FileModeToInt(mode fs.FileMode) int {
+	result := int(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		result |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		result |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		result |= 01000
+	}
+	return result
+}
@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"syscall"
+)
+
+type sysInode struct {
+	dev uint64
+	ino uint64
+}
+
+func sysFileID(sys interface{}) (interface{}, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok || uint64(st.Nlink) < 2 {
+		return nil, false
+	}
+	return sysInode{
+		dev: uint64(st.Dev),
+		ino: uint64(st.Ino),
+	}, true
+}
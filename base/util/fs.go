@@ -0,0 +1,257 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	slashpath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+)
+
+// ReadLinkFS is a filesystem that can read the targets of symlinks.
+// Trees containing symlinks can only be read from filesystems that
+// implement it.
+type ReadLinkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+}
+
+// FilesFS returns the filesystem for reading local files: options.FS if
+// specified, or else options.FilesDir, which can be a directory or an
+// archive.  It returns ErrNoFilesDir if neither is specified.  Unless
+// options.FollowSymlinks is set, a FilesDir filesystem refuses to follow
+// symlinks that resolve outside the directory.
+func FilesFS(options common.TranslateOptions) (fs.FS, error) {
+	if options.FS != nil {
+		return options.FS, nil
+	}
+	if options.FilesDir == "" {
+		return nil, common.ErrNoFilesDir
+	}
+	if info, err := os.Stat(options.FilesDir); err == nil && info.Mode().IsRegular() {
+		return openArchiveCached(options.FilesDir, info)
+	}
+	return dirFS{
+		dir:            options.FilesDir,
+		followSymlinks: options.FollowSymlinks,
+	}, nil
+}
+
+// LocalPath converts a slash-separated local path from a config to a path
+// within the files filesystem.  It fails if the path traverses outside the
+// filesystem.
+func LocalPath(local string) (string, error) {
+	name := slashpath.Clean(local)
+	if slashpath.IsAbs(name) {
+		// relative to the files directory, as with filepath.Join
+		name = strings.TrimLeft(name, "/")
+	}
+	if name == "" || name == "." || !fs.ValidPath(name) {
+		return "", common.ErrFilesDirEscape
+	}
+	return name, nil
+}
+
+// RecordPath returns the path of a file within fsys in the form used by
+// TranslationRecord.Files: the host path for a files directory, the path
+// of the archive for an archive, and the path within fsys otherwise.
+func RecordPath(fsys fs.FS, name string) string {
+	switch fsys := fsys.(type) {
+	case dirFS:
+		return filepath.Join(fsys.dir, filepath.FromSlash(name))
+	case *archiveFS:
+		return fsys.path
+	default:
+		return name
+	}
+}
+
+// ReadLink returns the target of a symlink in fsys.
+func ReadLink(fsys fs.FS, name string) (string, error) {
+	if rl, ok := fsys.(ReadLinkFS); ok {
+		return rl.ReadLink(name)
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: common.ErrReadLinkUnsupported}
+}
+
+// dirFS is a filesystem rooted at a host directory.  Unlike os.DirFS, it
+// reads symlinks, its errors include the full host path, and it checks that
+// symlinks don't lead outside the directory.
+//
+// The check resolves symlinks in the path, and the resolved path is then
+// opened.  A directory in the resolved path could be replaced by a symlink
+// in between, so after opening, the path is resolved again and must still
+// lead inside the directory, to the file that was opened.
+type dirFS struct {
+	dir            string
+	followSymlinks bool
+}
+
+// afterResolve, if set, is called with the resolved path before it's
+// used.  Tests use it to modify the directory at that point.
+var afterResolve func(string)
+
+// resolve returns the host path of name.  Unless symlinks are being
+// followed, it resolves symlinks in the path, and fails if the path
+// resolves outside the directory.  If resolveLast is false, a symlink in
+// the last path component is not resolved.
+func (d dirFS) resolve(op, name string, resolveLast bool) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	p := filepath.Join(d.dir, filepath.FromSlash(name))
+	if d.followSymlinks {
+		return p, nil
+	}
+	base, err := filepath.EvalSymlinks(d.dir)
+	if err != nil {
+		// let the caller report the problem
+		return p, nil
+	}
+	var resolved string
+	if resolveLast {
+		resolved, err = filepath.EvalSymlinks(p)
+	} else {
+		resolved, err = filepath.EvalSymlinks(filepath.Dir(p))
+		resolved = filepath.Join(resolved, filepath.Base(p))
+	}
+	if err != nil {
+		// nonexistent path; let the caller report the problem
+		return p, nil
+	}
+	if resolved != base && !strings.HasPrefix(resolved, base+string(filepath.Separator)) {
+		return "", common.ErrFilesDirEscape
+	}
+	if afterResolve != nil {
+		afterResolve(resolved)
+	}
+	return resolved, nil
+}
+
+// verify checks that name still resolves inside the directory, to the
+// file described by info, after the file was opened or examined.  If
+// resolveLast is false, info describes the symlink in the last path
+// component rather than its target.
+func (d dirFS) verify(op, name string, resolveLast bool, info fs.FileInfo) error {
+	if d.followSymlinks {
+		return nil
+	}
+	p, err := d.resolve(op, name, resolveLast)
+	if err != nil {
+		return err
+	}
+	var current fs.FileInfo
+	if resolveLast {
+		current, err = os.Stat(p)
+	} else {
+		current, err = os.Lstat(p)
+	}
+	if err != nil || !os.SameFile(info, current) {
+		return common.ErrFilesDirEscape
+	}
+	return nil
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	f, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens name and verifies that the opened file is inside the
+// directory.
+func (d dirFS) open(name string) (*os.File, error) {
+	p, err := d.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = d.verify("open", name, true, info)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d dirFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := d.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.verify("stat", name, true, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (d dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, err
+}
+
+func (d dirFS) ReadFile(name string) ([]byte, error) {
+	f, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (d dirFS) ReadLink(name string) (string, error) {
+	p, err := d.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(p)
+	if err != nil {
+		return "", err
+	}
+	target, err := os.Readlink(p)
+	if err != nil {
+		return "", err
+	}
+	if err := d.verify("readlink", name, false, info); err != nil {
+		return "", err
+	}
+	return target, nil
+}
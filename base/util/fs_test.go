@@ -0,0 +1,189 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+		err error
+	}{
+		{"file", "file", nil},
+		{"dir/file", "dir/file", nil},
+		{"./dir//file/", "dir/file", nil},
+		{"dir/../file", "file", nil},
+		{"/dir/file", "dir/file", nil},
+		{"/../file", "file", nil},
+		{"", "", common.ErrFilesDirEscape},
+		{".", "", common.ErrFilesDirEscape},
+		{"dir/..", "", common.ErrFilesDirEscape},
+		{"..", "", common.ErrFilesDirEscape},
+		{"../file", "", common.ErrFilesDirEscape},
+		{"dir/../../file", "", common.ErrFilesDirEscape},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("path %d", i), func(t *testing.T) {
+			out, err := LocalPath(test.in)
+			assert.Equal(t, test.err, err, "bad error")
+			assert.Equal(t, test.out, out, "bad path")
+		})
+	}
+}
+
+func TestFilesFSSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	filesDir := t.TempDir()
+	for _, p := range []string{filepath.Join(outside, "secret"), filepath.Join(filesDir, "dir", "file")} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"inside":         "dir/file",
+		"inside-dir":     "dir",
+		"escape":         filepath.Join(outside, "secret"),
+		"escape-rel":     filepath.Join("..", filepath.Base(outside), "secret"),
+		"escape-dir":     outside,
+		"dir/escape-dir": "../../" + filepath.Base(outside),
+	} {
+		if err := os.Symlink(target, filepath.Join(filesDir, filepath.FromSlash(link))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		follow bool
+		err    error
+	}{
+		{"dir/file", false, nil},
+		{"inside", false, nil},
+		{"inside-dir/file", false, nil},
+		{"escape", false, common.ErrFilesDirEscape},
+		{"escape-rel", false, common.ErrFilesDirEscape},
+		{"escape-dir/secret", false, common.ErrFilesDirEscape},
+		{"dir/escape-dir/secret", false, common.ErrFilesDirEscape},
+		{"escape", true, nil},
+		{"escape-dir/secret", true, nil},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("read %d", i), func(t *testing.T) {
+			fsys, err := FilesFS(common.TranslateOptions{
+				FilesDir:       filesDir,
+				FollowSymlinks: test.follow,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, err := fs.ReadFile(fsys, test.name)
+			assert.Equal(t, test.err, err, "bad read error")
+			_, err = fs.Stat(fsys, test.name)
+			assert.Equal(t, test.err, err, "bad stat error")
+			if test.err == nil {
+				assert.Equal(t, "contents", string(contents), "bad contents")
+			}
+		})
+	}
+
+	// reading an escaping symlink itself is fine
+	fsys, err := FilesFS(common.TranslateOptions{FilesDir: filesDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := ReadLink(fsys, "escape")
+	assert.NoError(t, err, "reading link failed")
+	assert.Equal(t, filepath.Join(outside, "secret"), target, "bad link target")
+}
+
+// TestFilesFSSymlinkRace checks that a directory replaced by a symlink
+// after the path was checked isn't followed outside the files directory.
+func TestFilesFSSymlinkRace(t *testing.T) {
+	tests := []struct {
+		// restore the directory before the opened file is verified
+		restore bool
+	}{
+		{false},
+		{true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("race %d", i), func(t *testing.T) {
+			outside := t.TempDir()
+			filesDir := t.TempDir()
+			dir := filepath.Join(filesDir, "dir")
+			for _, p := range []string{filepath.Join(outside, "file"), filepath.Join(dir, "file")} {
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(p), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			swap := func() {
+				if err := os.Rename(dir, dir+".orig"); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(outside, dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			unswap := func() {
+				if err := os.Remove(dir); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Rename(dir+".orig", dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			calls := 0
+			afterResolve = func(string) {
+				calls++
+				switch {
+				case calls == 1:
+					swap()
+				case calls == 2 && test.restore:
+					unswap()
+				}
+			}
+			defer func() {
+				afterResolve = nil
+			}()
+
+			fsys, err := FilesFS(common.TranslateOptions{FilesDir: filesDir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = fs.ReadFile(fsys, "dir/file")
+			assert.Equal(t, common.ErrFilesDirEscape, err, "bad read error")
+		})
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// MarshalJSON serializes structured contents as indented JSON with sorted
+// keys.
+func MarshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalYAML serializes structured contents as YAML with sorted keys.
+func MarshalYAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalTOML serializes structured contents, which must have passed
+// ValidateTOML, as TOML with sorted keys.  Each table is written with a
+// header, after the key/value pairs of its parent.
+func MarshalTOML(v interface{}) ([]byte, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, common.ErrStructuredNotMapping
+	}
+	var buf bytes.Buffer
+	if err := writeTOMLTable(&buf, nil, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalINI serializes structured contents, which must have passed
+// ValidateINI, as an INI file.  Top-level scalars become global keys,
+// mappings become sections, and lists become repeated keys.
+func MarshalINI(v interface{}) ([]byte, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, common.ErrStructuredNotMapping
+	}
+	var buf bytes.Buffer
+	writeINIKeys(&buf, m)
+	for _, key := range sortedKeys(m) {
+		section, ok := m[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "[%s]\n", key)
+		writeINIKeys(&buf, section)
+	}
+	return buf.Bytes(), nil
+}
+
+// ValidateJSON checks that structured contents can be serialized as JSON.
+func ValidateJSON(c path.ContextPath, v interface{}) (r report.Report) {
+	walkStructured(c, v, func(c path.ContextPath, v interface{}) bool {
+		if _, ok := v.(map[interface{}]interface{}); ok {
+			r.AddOnError(c, common.ErrStructuredKeyNotString)
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// ValidateTOML checks that structured contents can be serialized as TOML.
+func ValidateTOML(c path.ContextPath, v interface{}) (r report.Report) {
+	if _, ok := v.(map[string]interface{}); !ok {
+		r.AddOnError(c, common.ErrStructuredNotMapping)
+		return
+	}
+	walkStructured(c, v, func(c path.ContextPath, v interface{}) bool {
+		switch v.(type) {
+		case nil:
+			r.AddOnError(c, common.ErrStructuredNull)
+		case map[interface{}]interface{}:
+			r.AddOnError(c, common.ErrStructuredKeyNotString)
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// ValidateINI checks that structured contents can be serialized as INI:
+// a mapping of global keys and sections, each section a mapping of keys,
+// and each key a single-line scalar or a list of them.
+func ValidateINI(c path.ContextPath, v interface{}) (r report.Report) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.AddOnError(c, common.ErrStructuredNotMapping)
+		return
+	}
+	for _, key := range sortedKeys(m) {
+		if section, ok := m[key].(map[string]interface{}); ok {
+			if key == "" || strings.ContainsAny(key, "[]=\n") {
+				r.AddOnError(c.Append(key), common.ErrINIKey)
+			}
+			for _, sectionKey := range sortedKeys(section) {
+				r.Merge(validateINIKey(c.Append(key, sectionKey), sectionKey, section[sectionKey]))
+			}
+		} else {
+			r.Merge(validateINIKey(c.Append(key), key, m[key]))
+		}
+	}
+	return
+}
+
+func validateINIKey(c path.ContextPath, key string, v interface{}) (r report.Report) {
+	if key == "" || strings.ContainsAny(key, "[]=\n") {
+		r.AddOnError(c, common.ErrINIKey)
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		values = []interface{}{v}
+	}
+	for i, value := range values {
+		valuePath := c
+		if ok {
+			valuePath = c.Append(i)
+		}
+		switch value := value.(type) {
+		case nil:
+			r.AddOnError(valuePath, common.ErrStructuredNull)
+		case string:
+			if strings.Contains(value, "\n") {
+				r.AddOnError(valuePath, common.ErrINIMultiline)
+			}
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			r.AddOnError(valuePath, common.ErrININesting)
+		}
+	}
+	return
+}
+
+// walkStructured calls fn on v and, while fn returns true, on each value
+// nested within it.
+func walkStructured(c path.ContextPath, v interface{}, fn func(path.ContextPath, interface{}) bool) {
+	if !fn(c, v) {
+		return
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			walkStructured(c.Append(key), v[key], fn)
+		}
+	case []interface{}:
+		for i, item := range v {
+			walkStructured(c.Append(i), item, fn)
+		}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isTOMLTable returns true if v is written as a table or array of tables
+// rather than as a key/value pair.
+func isTOMLTable(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		if len(v) == 0 {
+			return false
+		}
+		for _, item := range v {
+			if _, ok := item.(map[string]interface{}); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func writeTOMLTable(buf *bytes.Buffer, tablePath []string, m map[string]interface{}) error {
+	keys := sortedKeys(m)
+	for _, key := range keys {
+		if isTOMLTable(m[key]) {
+			continue
+		}
+		value, err := tomlValue(m[key])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "%s = %s\n", tomlKey(key), value)
+	}
+	for _, key := range keys {
+		if !isTOMLTable(m[key]) {
+			continue
+		}
+		childPath := append(append([]string{}, tablePath...), tomlKey(key))
+		header := strings.Join(childPath, ".")
+		var tables []interface{}
+		if table, ok := m[key].(map[string]interface{}); ok {
+			tables = []interface{}{table}
+		} else {
+			tables = m[key].([]interface{})
+			header = "[" + header + "]"
+		}
+		for _, table := range tables {
+			if buf.Len() > 0 {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(buf, "[%s]\n", header)
+			if err := writeTOMLTable(buf, childPath, table.(map[string]interface{})); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func tomlKey(key string) string {
+	if tomlBareKey.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteString(`"`)
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteString(`"`)
+	return b.String()
+}
+
+func tomlValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return tomlString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "nan", nil
+		case math.IsInf(v, 1):
+			return "inf", nil
+		case math.IsInf(v, -1):
+			return "-inf", nil
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		return s, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			value, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]interface{}:
+		items := make([]string, 0, len(v))
+		for _, key := range sortedKeys(v) {
+			value, err := tomlValue(v[key])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, tomlKey(key)+" = "+value)
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	case nil:
+		return "", common.ErrStructuredNull
+	default:
+		return "", fmt.Errorf("unsupported TOML value type %T", v)
+	}
+}
+
+func writeINIKeys(buf *bytes.Buffer, m map[string]interface{}) {
+	for _, key := range sortedKeys(m) {
+		switch v := m[key].(type) {
+		case map[string]interface{}:
+			// section
+		case []interface{}:
+			for _, item := range v {
+				fmt.Fprintf(buf, "%s=%s\n", key, iniValue(item))
+			}
+		default:
+			fmt.Fprintf(buf, "%s=%s\n", key, iniValue(v))
+		}
+	}
+}
+
+func iniValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func parseStructured(t *testing.T, in string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(in), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMarshalStructured(t *testing.T) {
+	tests := []struct {
+		in      string
+		marshal func(interface{}) ([]byte, error)
+		out     string
+	}{
+		{
+			"{b: [1, 2.5, x<y], a: {n: null}}",
+			MarshalJSON,
+			"{\n  \"a\": {\n    \"n\": null\n  },\n  \"b\": [\n    1,\n    2.5,\n    \"x<y\"\n  ]\n}\n",
+		},
+		{
+			"{z: 1, a: [x]}",
+			MarshalYAML,
+			"a:\n  - x\nz: 1\n",
+		},
+		{
+			`
+title: "say \"hi\"\n"
+"odd key": 2.0
+nums: [1, {x: true}]
+owner: {name: Tom, address: {city: x}}
+servers: [{name: alpha}, {name: beta}]
+`,
+			MarshalTOML,
+			`nums = [1, {x = true}]
+"odd key" = 2.0
+title = "say \"hi\"\n"
+
+[owner]
+name = "Tom"
+
+[owner.address]
+city = "x"
+
+[[servers]]
+name = "alpha"
+
+[[servers]]
+name = "beta"
+`,
+		},
+		{
+			"{global: 1, Unit: {Description: x, After: [a.service, b.service]}, Install: {WantedBy: y}}",
+			MarshalINI,
+			"global=1\n\n[Install]\nWantedBy=y\n\n[Unit]\nAfter=a.service\nAfter=b.service\nDescription=x\n",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("marshal %d", i), func(t *testing.T) {
+			out, err := test.marshal(parseStructured(t, test.in))
+			assert.NoError(t, err, "marshaling failed")
+			assert.Equal(t, test.out, string(out), "bad output")
+		})
+	}
+}
+
+func TestValidateStructured(t *testing.T) {
+	tests := []struct {
+		in       string
+		validate func(path.ContextPath, interface{}) report.Report
+		out      error
+		errPath  path.ContextPath
+	}{
+		{
+			"{a: [1, {b: null}]}",
+			ValidateJSON,
+			nil,
+			path.New("yaml"),
+		},
+		{
+			"{a: {1: x}}",
+			ValidateJSON,
+			common.ErrStructuredKeyNotString,
+			path.New("yaml", "a"),
+		},
+		{
+			"[1, 2]",
+			ValidateTOML,
+			common.ErrStructuredNotMapping,
+			path.New("yaml"),
+		},
+		{
+			"{a: [1, null]}",
+			ValidateTOML,
+			common.ErrStructuredNull,
+			path.New("yaml", "a", 1),
+		},
+		{
+			"{a: 1, s: {b: [x, y]}}",
+			ValidateINI,
+			nil,
+			path.New("yaml"),
+		},
+		{
+			"{s: {b: {c: d}}}",
+			ValidateINI,
+			common.ErrININesting,
+			path.New("yaml", "s", "b"),
+		},
+		{
+			"{s: {b: [x, [y]]}}",
+			ValidateINI,
+			common.ErrININesting,
+			path.New("yaml", "s", "b", 1),
+		},
+		{
+			"{s: {b: \"x\\ny\"}}",
+			ValidateINI,
+			common.ErrINIMultiline,
+			path.New("yaml", "s", "b"),
+		},
+		{
+			"{s: {a=b: c}}",
+			ValidateINI,
+			common.ErrINIKey,
+			path.New("yaml", "s", "a=b"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.validate(path.New("yaml"), parseStructured(t, test.in))
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"io/fs"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/tree"
+	"gopkg.in/yaml.v3"
+)
+
+// TemplateData returns the data for rendering templates: the mapping in
+// the YAML or JSON file local within fsys, if specified, updated with the
+// keys of inline.  The file is recorded in record.
+func TemplateData(fsys fs.FS, local *string, inline map[string]interface{}, record *common.TranslationRecord) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	if local != nil {
+		name, err := LocalPath(*local)
+		if err != nil {
+			return nil, err
+		}
+		record.AddFile(RecordPath(fsys, name))
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var node yaml.Node
+		if err := yaml.Unmarshal(contents, &node); err != nil {
+			return nil, err
+		}
+		if len(node.Content) > 0 {
+			if node.Content[0].Kind != yaml.MappingNode {
+				return nil, common.ErrTemplateDataNotMapping
+			}
+			if err := node.Decode(&data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for k, v := range inline {
+		data[k] = v
+	}
+	return data, nil
+}
+
+// RenderTemplate renders contents as a text/template with the specified
+// data.  Errors name the template and the line number within it, and
+// references to missing keys are errors.
+func RenderTemplate(name string, contents []byte, data map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(contents))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// TemplateMarker returns a marker for the line, and column if known, of
+// the template name that an error from RenderTemplate refers to.  It
+// returns false if the error doesn't refer to a line.
+func TemplateMarker(name string, err error) (tree.Marker, bool) {
+	// text/template errors start with "template: NAME:LINE[:COL]: "
+	rest := strings.TrimPrefix(err.Error(), "template: "+name+":")
+	if rest == err.Error() {
+		return tree.Marker{}, false
+	}
+	fields := strings.SplitN(rest, ":", 3)
+	line, lineErr := strconv.ParseInt(fields[0], 10, 64)
+	if lineErr != nil || line <= 0 {
+		return tree.Marker{}, false
+	}
+	pos := tree.Pos{
+		Line: line,
+	}
+	if len(fields) == 3 {
+		// text/template columns are 0-based
+		if column, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			pos.Column = column + 1
+		}
+	}
+	return tree.Marker{
+		StartP: &pos,
+	}, true
+}
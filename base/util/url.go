@@ -18,6 +18,8 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
+	"fmt"
+	"io/ioutil"
 	"net/url"
 
 	"github.com/coreos/ignition/v2/config/util"
@@ -81,3 +83,24 @@ func MakeDataURL(contents []byte, currentCompression *string, allowCompression b
 	}).String()
 	return
 }
+
+// DecodeDataURL returns the contents of a data URL produced by MakeDataURL,
+// decompressing them if compression is "gzip".
+func DecodeDataURL(uri string, compression *string) ([]byte, error) {
+	decoded, err := dataurl.DecodeString(uri)
+	if err != nil {
+		return nil, err
+	}
+	if util.NilOrEmpty(compression) {
+		return decoded.Data, nil
+	}
+	if *compression != "gzip" {
+		return nil, fmt.Errorf("unsupported compression %q", *compression)
+	}
+	decompressor, err := gzip.NewReader(bytes.NewReader(decoded.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+	return ioutil.ReadAll(decompressor)
+}
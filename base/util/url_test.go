@@ -0,0 +1,42 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeDataURL(t *testing.T) {
+	tests := [][]byte{
+		{},
+		[]byte("hello world\n"),
+		[]byte(strings.Repeat("compressible ", 100)),
+		{0, 1, 2, 255},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("decode %d", i), func(t *testing.T) {
+			uri, compression, err := MakeDataURL(test, nil, true)
+			assert.NoError(t, err, "encoding failed")
+			decoded, err := DecodeDataURL(uri, compression)
+			assert.NoError(t, err, "decoding failed")
+			assert.Equal(t, string(test), string(decoded), "bad round trip")
+		})
+	}
+}
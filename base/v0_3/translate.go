@@ -16,8 +16,7 @@ package v0_3
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io/fs"
 	slashpath "path"
 	"path/filepath"
 	"strings"
@@ -132,20 +131,22 @@ func translateResource(from Resource, options common.TranslateOptions) (to types
 	if from.Local != nil {
 		c := path.New("yaml", "local")
 
-		if options.FilesDir == "" {
-			r.AddOnError(c, common.ErrNoFilesDir)
+		fsys, err := baseutil.FilesFS(options)
+		if err != nil {
+			r.AddOnError(c, err)
 			return
 		}
 
-		// calculate file path within FilesDir and check for
-		// path traversal
-		filePath := filepath.Join(options.FilesDir, filepath.FromSlash(*from.Local))
-		if err := baseutil.EnsurePathWithinFilesDir(filePath, options.FilesDir); err != nil {
+		// calculate file path within the files filesystem and
+		// check for path traversal
+		filePath, err := baseutil.LocalPath(*from.Local)
+		if err != nil {
 			r.AddOnError(c, err)
 			return
 		}
+		options.Record.AddFile(baseutil.RecordPath(fsys, filePath))
 
-		contents, err := ioutil.ReadFile(filePath)
+		contents, err := fs.ReadFile(fsys, filePath)
 		if err != nil {
 			r.AddOnError(c, err)
 			return
@@ -213,19 +214,21 @@ func (c Config) processTrees(ret *types.Config, options common.TranslateOptions)
 
 	for i, tree := range c.Storage.Trees {
 		yamlPath := path.New("yaml", "storage", "trees", i)
-		if options.FilesDir == "" {
-			r.AddOnError(yamlPath, common.ErrNoFilesDir)
+		fsys, err := baseutil.FilesFS(options)
+		if err != nil {
+			r.AddOnError(yamlPath, err)
 			return ts, r
 		}
 
-		// calculate base path within FilesDir and check for
-		// path traversal
-		srcBaseDir := filepath.Join(options.FilesDir, filepath.FromSlash(tree.Local))
-		if err := baseutil.EnsurePathWithinFilesDir(srcBaseDir, options.FilesDir); err != nil {
+		// calculate base path within the files filesystem and
+		// check for path traversal
+		srcBaseDir, err := baseutil.LocalPath(tree.Local)
+		if err != nil {
 			r.AddOnError(yamlPath, err)
 			continue
 		}
-		info, err := os.Stat(srcBaseDir)
+		options.Record.AddFile(baseutil.RecordPath(fsys, srcBaseDir))
+		info, err := fs.Stat(fsys, srcBaseDir)
 		if err != nil {
 			r.AddOnError(yamlPath, err)
 			continue
@@ -239,26 +242,27 @@ func (c Config) processTrees(ret *types.Config, options common.TranslateOptions)
 			destBaseDir = *tree.Path
 		}
 
-		walkTree(yamlPath, &ts, &r, t, srcBaseDir, destBaseDir, options)
+		walkTree(yamlPath, &ts, &r, t, fsys, srcBaseDir, destBaseDir, options)
 	}
 	return ts, r
 }
 
-func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report.Report, t *nodeTracker, srcBaseDir, destBaseDir string, options common.TranslateOptions) {
+func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report.Report, t *nodeTracker, fsys fs.FS, srcBaseDir, destBaseDir string, options common.TranslateOptions) {
 	// The strategy for errors within WalkFunc is to add an error to
 	// the report and return nil, so walking continues but translation
 	// will fail afterward.
-	err := filepath.Walk(srcBaseDir, func(srcPath string, info os.FileInfo, err error) error {
+	err := fs.WalkDir(fsys, srcBaseDir, func(srcPath string, d fs.DirEntry, err error) error {
+		options.Record.AddFile(baseutil.RecordPath(fsys, srcPath))
 		if err != nil {
 			r.AddOnError(yamlPath, err)
 			return nil
 		}
-		relPath, err := filepath.Rel(srcBaseDir, srcPath)
+		info, err := d.Info()
 		if err != nil {
 			r.AddOnError(yamlPath, err)
 			return nil
 		}
-		destPath := slashpath.Join(destBaseDir, filepath.ToSlash(relPath))
+		destPath := slashpath.Join(destBaseDir, strings.TrimPrefix(srcPath, srcBaseDir))
 
 		if info.Mode().IsDir() {
 			return nil
@@ -284,7 +288,7 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 					ts.AddTranslation(yamlPath, path.New("json", "storage", "files"))
 				}
 			}
-			contents, err := ioutil.ReadFile(srcPath)
+			contents, err := fs.ReadFile(fsys, srcPath)
 			if err != nil {
 				r.AddOnError(yamlPath, err)
 				return nil
@@ -309,7 +313,7 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 				file.Mode = &mode
 				ts.AddTranslation(yamlPath, path.New("json", "storage", "files", i, "mode"))
 			}
-		} else if info.Mode()&os.ModeType == os.ModeSymlink {
+		} else if info.Mode()&fs.ModeType == fs.ModeSymlink {
 			i, link := t.GetLink(destPath)
 			if link != nil {
 				if link.Target != "" {
@@ -331,7 +335,7 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 					ts.AddTranslation(yamlPath, path.New("json", "storage", "links"))
 				}
 			}
-			target, err := os.Readlink(srcPath)
+			target, err := baseutil.ReadLink(fsys, srcPath)
 			if err != nil {
 				r.AddOnError(yamlPath, err)
 				return nil
@@ -201,12 +201,19 @@ type Raid struct {
 type RaidOption string
 
 type Resource struct {
-	Compression  *string      `yaml:"compression"`
-	HTTPHeaders  HTTPHeaders  `yaml:"http_headers"`
-	Source       *string      `yaml:"source"`
-	Inline       *string      `yaml:"inline"` // Added, not in ignition spec
-	Local        *string      `yaml:"local"`  // Added, not in ignition spec
-	Verification Verification `yaml:"verification"`
+	Compression       *string                `yaml:"compression"`
+	HTTPHeaders       HTTPHeaders            `yaml:"http_headers"`
+	Source            *string                `yaml:"source"`
+	Inline            *string                `yaml:"inline"`              // Added, not in ignition spec
+	Local             *string                `yaml:"local"`               // Added, not in ignition spec
+	JSON              interface{}            `yaml:"json"`                // Added, not in ignition spec
+	YAML              interface{}            `yaml:"yaml"`                // Added, not in ignition spec
+	TOML              interface{}            `yaml:"toml"`                // Added, not in ignition spec
+	INI               interface{}            `yaml:"ini"`                 // Added, not in ignition spec
+	Template          *bool                  `yaml:"template"`            // Added, not in ignition spec
+	TemplateData      map[string]interface{} `yaml:"template_data"`       // Added, not in ignition spec
+	TemplateDataLocal *string                `yaml:"template_data_local"` // Added, not in ignition spec
+	Verification      Verification           `yaml:"verification"`
 }
 
 type SSHAuthorizedKey string
@@ -245,8 +252,30 @@ type Timeouts struct {
 }
 
 type Tree struct {
-	Local string  `yaml:"local"`
-	Path  *string `yaml:"path"`
+	Exclude           []string               `yaml:"exclude"`
+	Group             NodeGroup              `yaml:"group"`
+	Include           []string               `yaml:"include"`
+	Local             string                 `yaml:"local"`
+	Overrides         []TreeOverride         `yaml:"overrides"`
+	Path              *string                `yaml:"path"`
+	Preserve          TreePreserve           `yaml:"preserve"`
+	Template          *bool                  `yaml:"template"`
+	TemplateData      map[string]interface{} `yaml:"template_data"`
+	TemplateDataLocal *string                `yaml:"template_data_local"`
+	User              NodeUser               `yaml:"user"`
+}
+
+type TreeOverride struct {
+	Group NodeGroup `yaml:"group"`
+	Match string    `yaml:"match"`
+	Mode  *int      `yaml:"mode"`
+	User  NodeUser  `yaml:"user"`
+}
+
+type TreePreserve struct {
+	Directories *bool `yaml:"directories"`
+	Hardlinks   *bool `yaml:"hardlinks"`
+	Mode        *bool `yaml:"mode"`
 }
 
 type Unit struct {
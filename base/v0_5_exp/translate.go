@@ -16,8 +16,7 @@ package v0_5_exp
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io/fs"
 	slashpath "path"
 	"path/filepath"
 	"strings"
@@ -147,25 +146,72 @@ func translateResource(from Resource, options common.TranslateOptions) (to types
 	if from.Local != nil {
 		c := path.New("yaml", "local")
 
-		if options.FilesDir == "" {
-			r.AddOnError(c, common.ErrNoFilesDir)
+		fsys, err := baseutil.FilesFS(options)
+		if err != nil {
+			r.AddOnError(c, err)
 			return
 		}
 
-		// calculate file path within FilesDir and check for
-		// path traversal
-		filePath := filepath.Join(options.FilesDir, filepath.FromSlash(*from.Local))
-		if err := baseutil.EnsurePathWithinFilesDir(filePath, options.FilesDir); err != nil {
+		// calculate file path within the files filesystem and
+		// check for path traversal
+		filePath, err := baseutil.LocalPath(*from.Local)
+		if err != nil {
 			r.AddOnError(c, err)
 			return
 		}
+		options.Record.AddFile(baseutil.RecordPath(fsys, filePath))
 
-		contents, err := ioutil.ReadFile(filePath)
+		contents, err := fs.ReadFile(fsys, filePath)
 		if err != nil {
 			r.AddOnError(c, err)
 			return
 		}
+		if util.IsTrue(from.Template) {
+			data, err := baseutil.TemplateData(fsys, from.TemplateDataLocal, from.TemplateData, options.Record)
+			if err != nil {
+				r.AddOnError(path.New("yaml", "template_data_local"), err)
+				return
+			}
+			contents, err = baseutil.RenderTemplate(filePath, contents, data)
+			if err != nil {
+				addTemplateError(&r, c, filePath, err)
+				return
+			}
+		}
+
+		src, compression, err := baseutil.MakeDataURL(contents, to.Compression, !options.NoResourceAutoCompression)
+		if err != nil {
+			r.AddOnError(c, err)
+			return
+		}
+		to.Source = &src
+		tm.AddTranslation(c, path.New("json", "source"))
+		if compression != nil {
+			to.Compression = compression
+			tm.AddTranslation(c, path.New("json", "compression"))
+		}
+	}
 
+	for _, structured := range []struct {
+		field   string
+		value   interface{}
+		marshal func(interface{}) ([]byte, error)
+	}{
+		{"json", from.JSON, baseutil.MarshalJSON},
+		{"yaml", from.YAML, baseutil.MarshalYAML},
+		{"toml", from.TOML, baseutil.MarshalTOML},
+		{"ini", from.INI, baseutil.MarshalINI},
+	} {
+		if structured.value == nil {
+			continue
+		}
+		c := path.New("yaml", structured.field)
+
+		contents, err := structured.marshal(structured.value)
+		if err != nil {
+			r.AddOnError(c, err)
+			return
+		}
 		src, compression, err := baseutil.MakeDataURL(contents, to.Compression, !options.NoResourceAutoCompression)
 		if err != nil {
 			r.AddOnError(c, err)
@@ -228,19 +274,21 @@ func (c Config) processTrees(ret *types.Config, options common.TranslateOptions)
 
 	for i, tree := range c.Storage.Trees {
 		yamlPath := path.New("yaml", "storage", "trees", i)
-		if options.FilesDir == "" {
-			r.AddOnError(yamlPath, common.ErrNoFilesDir)
+		fsys, err := baseutil.FilesFS(options)
+		if err != nil {
+			r.AddOnError(yamlPath, err)
 			return ts, r
 		}
 
-		// calculate base path within FilesDir and check for
-		// path traversal
-		srcBaseDir := filepath.Join(options.FilesDir, filepath.FromSlash(tree.Local))
-		if err := baseutil.EnsurePathWithinFilesDir(srcBaseDir, options.FilesDir); err != nil {
+		// calculate base path within the files filesystem and
+		// check for path traversal
+		srcBaseDir, err := baseutil.LocalPath(tree.Local)
+		if err != nil {
 			r.AddOnError(yamlPath, err)
 			continue
 		}
-		info, err := os.Stat(srcBaseDir)
+		options.Record.AddFile(baseutil.RecordPath(fsys, srcBaseDir))
+		info, err := fs.Stat(fsys, srcBaseDir)
 		if err != nil {
 			r.AddOnError(yamlPath, err)
 			continue
@@ -253,31 +301,108 @@ func (c Config) processTrees(ret *types.Config, options common.TranslateOptions)
 		if util.NotEmpty(tree.Path) {
 			destBaseDir = *tree.Path
 		}
+		var templateData map[string]interface{}
+		if util.IsTrue(tree.Template) {
+			templateData, err = baseutil.TemplateData(fsys, tree.TemplateDataLocal, tree.TemplateData, options.Record)
+			if err != nil {
+				r.AddOnError(path.New("yaml", "storage", "trees", i, "template_data_local"), err)
+				continue
+			}
+		}
 
-		walkTree(yamlPath, &ts, &r, t, srcBaseDir, destBaseDir, options)
+		walkTree(yamlPath, &ts, &r, t, fsys, srcBaseDir, destBaseDir, tree, templateData, options)
 	}
 	return ts, r
 }
 
-func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report.Report, t *nodeTracker, srcBaseDir, destBaseDir string, options common.TranslateOptions) {
+func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report.Report, t *nodeTracker, fsys fs.FS, srcBaseDir, destBaseDir string, tree Tree, templateData map[string]interface{}, options common.TranslateOptions) {
+	// destination paths of hard-linked files already emitted, by
+	// underlying file
+	hardlinks := make(map[interface{}]string)
+
+	// with include patterns, only directories containing something
+	// included are preserved
+	var includedDirs map[string]bool
+	if len(tree.Include) > 0 && util.IsTrue(tree.Preserve.Directories) {
+		includedDirs = treeIncludedDirs(fsys, srcBaseDir, tree)
+	}
+
 	// The strategy for errors within WalkFunc is to add an error to
 	// the report and return nil, so walking continues but translation
 	// will fail afterward.
-	err := filepath.Walk(srcBaseDir, func(srcPath string, info os.FileInfo, err error) error {
+	err := fs.WalkDir(fsys, srcBaseDir, func(srcPath string, d fs.DirEntry, err error) error {
+		relPath := strings.TrimPrefix(srcPath, srcBaseDir+"/")
+		if err == nil && srcPath != srcBaseDir {
+			if treeGlobsMatch(tree.Exclude, relPath) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() && len(tree.Include) > 0 && !treeGlobsMatch(tree.Include, relPath) {
+				return nil
+			}
+		}
+		options.Record.AddFile(baseutil.RecordPath(fsys, srcPath))
 		if err != nil {
 			r.AddOnError(yamlPath, err)
 			return nil
 		}
-		relPath, err := filepath.Rel(srcBaseDir, srcPath)
+		info, err := d.Info()
 		if err != nil {
 			r.AddOnError(yamlPath, err)
 			return nil
 		}
-		destPath := slashpath.Join(destBaseDir, filepath.ToSlash(relPath))
+		destPath := slashpath.Join(destBaseDir, strings.TrimPrefix(srcPath, srcBaseDir))
 
 		if info.Mode().IsDir() {
-			return nil
+			if !util.IsTrue(tree.Preserve.Directories) || srcPath == srcBaseDir {
+				return nil
+			}
+			if includedDirs != nil && !includedDirs[srcPath] {
+				return nil
+			}
+			i, dir := t.GetDir(destPath)
+			if dir == nil {
+				if t.Exists(destPath) {
+					r.AddOnError(yamlPath, common.ErrNodeExists)
+					return nil
+				}
+				i, dir = t.AddDir(types.Directory{
+					Node: types.Node{
+						Path: destPath,
+					},
+				})
+				ts.AddFromCommonSource(yamlPath, path.New("json", "storage", "directories", i), dir)
+				if i == 0 {
+					ts.AddTranslation(yamlPath, path.New("json", "storage", "directories"))
+				}
+			}
+			attrs := treeNodeAttributes(yamlPath, tree, relPath)
+			attrs.apply(ts, &dir.Node, "directories", i)
+			if dir.Mode == nil {
+				mode, modePath := treeNodeMode(yamlPath, r, tree, attrs, srcPath, info)
+				dir.Mode = &mode
+				ts.AddTranslation(modePath, path.New("json", "storage", "directories", i, "mode"))
+			}
 		} else if info.Mode().IsRegular() {
+			if util.IsTrue(tree.Preserve.Hardlinks) {
+				if id, ok := baseutil.FileID(info); ok {
+					if target, seen := hardlinks[id]; seen {
+						i, link := getTreeLink(yamlPath, ts, r, t, destPath)
+						if link == nil {
+							return nil
+						}
+						hardlinksPath := yamlPath.Append("preserve", "hardlinks").Copy()
+						link.Target = util.StrToPtr(target)
+						link.Hard = util.BoolToPtr(true)
+						ts.AddTranslation(hardlinksPath, path.New("json", "storage", "links", i, "target"))
+						ts.AddTranslation(hardlinksPath, path.New("json", "storage", "links", i, "hard"))
+						return nil
+					}
+					hardlinks[id] = destPath
+				}
+			}
 			i, file := t.GetFile(destPath)
 			if file != nil {
 				if util.NotEmpty(file.Contents.Source) {
@@ -299,11 +424,18 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 					ts.AddTranslation(yamlPath, path.New("json", "storage", "files"))
 				}
 			}
-			contents, err := ioutil.ReadFile(srcPath)
+			contents, err := fs.ReadFile(fsys, srcPath)
 			if err != nil {
 				r.AddOnError(yamlPath, err)
 				return nil
 			}
+			if templateData != nil {
+				contents, err = baseutil.RenderTemplate(srcPath, contents, templateData)
+				if err != nil {
+					addTemplateError(r, yamlPath, srcPath, err)
+					return nil
+				}
+			}
 			url, compression, err := baseutil.MakeDataURL(contents, file.Contents.Compression, !options.NoResourceAutoCompression)
 			if err != nil {
 				r.AddOnError(yamlPath, err)
@@ -316,43 +448,27 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 				ts.AddTranslation(yamlPath, path.New("json", "storage", "files", i, "contents", "compression"))
 			}
 			ts.AddTranslation(yamlPath, path.New("json", "storage", "files", i, "contents"))
+			attrs := treeNodeAttributes(yamlPath, tree, relPath)
+			attrs.apply(ts, &file.Node, "files", i)
 			if file.Mode == nil {
-				mode := 0644
-				if info.Mode()&0111 != 0 {
-					mode = 0755
-				}
+				mode, modePath := treeNodeMode(yamlPath, r, tree, attrs, srcPath, info)
 				file.Mode = &mode
-				ts.AddTranslation(yamlPath, path.New("json", "storage", "files", i, "mode"))
+				ts.AddTranslation(modePath, path.New("json", "storage", "files", i, "mode"))
 			}
-		} else if info.Mode()&os.ModeType == os.ModeSymlink {
-			i, link := t.GetLink(destPath)
-			if link != nil {
-				if util.NotEmpty(link.Target) {
-					r.AddOnError(yamlPath, common.ErrNodeExists)
-					return nil
-				}
-			} else {
-				if t.Exists(destPath) {
-					r.AddOnError(yamlPath, common.ErrNodeExists)
-					return nil
-				}
-				i, link = t.AddLink(types.Link{
-					Node: types.Node{
-						Path: destPath,
-					},
-				})
-				ts.AddFromCommonSource(yamlPath, path.New("json", "storage", "links", i), link)
-				if i == 0 {
-					ts.AddTranslation(yamlPath, path.New("json", "storage", "links"))
-				}
+		} else if info.Mode()&fs.ModeType == fs.ModeSymlink {
+			i, link := getTreeLink(yamlPath, ts, r, t, destPath)
+			if link == nil {
+				return nil
 			}
-			target, err := os.Readlink(srcPath)
+			target, err := baseutil.ReadLink(fsys, srcPath)
 			if err != nil {
 				r.AddOnError(yamlPath, err)
 				return nil
 			}
 			link.Target = util.StrToPtr(filepath.ToSlash(target))
 			ts.AddTranslation(yamlPath, path.New("json", "storage", "links", i, "target"))
+			attrs := treeNodeAttributes(yamlPath, tree, relPath)
+			attrs.apply(ts, &link.Node, "links", i)
 		} else {
 			r.AddOnError(yamlPath, common.ErrFileType)
 			return nil
@@ -362,6 +478,177 @@ func walkTree(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report
 	r.AddOnError(yamlPath, err)
 }
 
+// getTreeLink returns the links entry for destPath, adding one if the
+// config doesn't already specify it.  It reports an error and returns a
+// nil link if the path is already in use.
+func getTreeLink(yamlPath path.ContextPath, ts *translate.TranslationSet, r *report.Report, t *nodeTracker, destPath string) (int, *types.Link) {
+	i, link := t.GetLink(destPath)
+	if link != nil {
+		if util.NotEmpty(link.Target) {
+			r.AddOnError(yamlPath, common.ErrNodeExists)
+			return 0, nil
+		}
+		return i, link
+	}
+	if t.Exists(destPath) {
+		r.AddOnError(yamlPath, common.ErrNodeExists)
+		return 0, nil
+	}
+	i, link = t.AddLink(types.Link{
+		Node: types.Node{
+			Path: destPath,
+		},
+	})
+	ts.AddFromCommonSource(yamlPath, path.New("json", "storage", "links", i), link)
+	if i == 0 {
+		ts.AddTranslation(yamlPath, path.New("json", "storage", "links"))
+	}
+	return i, link
+}
+
+// addTemplateError reports an error rendering the template name at c.  If
+// the error refers to a line of the template, the entry's marker points to
+// it, since the config has no location for the template's contents.
+func addTemplateError(r *report.Report, c path.ContextPath, name string, err error) {
+	r.AddOnError(c, err)
+	if marker, ok := baseutil.TemplateMarker(name, err); ok {
+		r.Entries[len(r.Entries)-1].Marker = marker
+	}
+}
+
+// treeNodeMode returns the mode of a file or directory created from a
+// tree, and the config path that specified it.  A matching override takes
+// precedence over the mode of the local file.  Unless the tree preserves
+// modes, directories get 0755 and files get 0644 or, if executable, 0755.
+func treeNodeMode(yamlPath path.ContextPath, r *report.Report, tree Tree, attrs treeAttributes, srcPath string, info fs.FileInfo) (int, path.ContextPath) {
+	if attrs.mode != nil {
+		return *attrs.mode, attrs.modePath
+	}
+	if util.IsTrue(tree.Preserve.Mode) {
+		modePath := yamlPath.Append("preserve", "mode").Copy()
+		mode := baseutil.FileModeToInt(info.Mode())
+		if err := baseutil.CheckForUnusualMode(mode, info.IsDir()); err != nil {
+			r.AddOnWarn(modePath, fmt.Errorf("%s: %w (%#o)", srcPath, err, mode))
+		}
+		return mode, modePath
+	}
+	if info.IsDir() || info.Mode()&0111 != 0 {
+		return 0755, yamlPath
+	}
+	return 0644, yamlPath
+}
+
+// treeAttributes are the ownership and mode of a node created from a tree,
+// with the config paths that specified them.
+type treeAttributes struct {
+	user      NodeUser
+	userPath  path.ContextPath
+	group     NodeGroup
+	groupPath path.ContextPath
+	mode      *int
+	modePath  path.ContextPath
+}
+
+// treeNodeAttributes returns the attributes of the tree node at relPath:
+// the tree's default ownership, overridden field by field by each matching
+// override in turn.
+func treeNodeAttributes(yamlPath path.ContextPath, tree Tree, relPath string) treeAttributes {
+	var attrs treeAttributes
+	if tree.User.ID != nil || tree.User.Name != nil {
+		attrs.user = tree.User
+		attrs.userPath = yamlPath.Append("user").Copy()
+	}
+	if tree.Group.ID != nil || tree.Group.Name != nil {
+		attrs.group = tree.Group
+		attrs.groupPath = yamlPath.Append("group").Copy()
+	}
+	for i, override := range tree.Overrides {
+		if !treeGlobsMatch([]string{override.Match}, relPath) {
+			continue
+		}
+		overridePath := yamlPath.Append("overrides", i)
+		if override.User.ID != nil || override.User.Name != nil {
+			attrs.user = override.User
+			attrs.userPath = overridePath.Append("user").Copy()
+		}
+		if override.Group.ID != nil || override.Group.Name != nil {
+			attrs.group = override.Group
+			attrs.groupPath = overridePath.Append("group").Copy()
+		}
+		if override.Mode != nil {
+			attrs.mode = override.Mode
+			attrs.modePath = overridePath.Append("mode").Copy()
+		}
+	}
+	return attrs
+}
+
+// apply sets the ownership of node, entry i of the specified storage
+// section, unless the config already specifies it in a corresponding files
+// or links entry.
+func (attrs treeAttributes) apply(ts *translate.TranslationSet, node *types.Node, section string, i int) {
+	if attrs.userPath.Len() > 0 && node.User.ID == nil && node.User.Name == nil {
+		node.User = types.NodeUser{
+			ID:   attrs.user.ID,
+			Name: attrs.user.Name,
+		}
+		ts.AddFromCommonSource(attrs.userPath, path.New("json", "storage", section, i, "user"), node.User)
+	}
+	if attrs.groupPath.Len() > 0 && node.Group.ID == nil && node.Group.Name == nil {
+		node.Group = types.NodeGroup{
+			ID:   attrs.group.ID,
+			Name: attrs.group.Name,
+		}
+		ts.AddFromCommonSource(attrs.groupPath, path.New("json", "storage", section, i, "group"), node.Group)
+	}
+}
+
+// treeIncludedDirs returns the directories under srcBaseDir that contain
+// files or symlinks selected by the include and exclude patterns of the
+// tree, so walkTree can preserve only those directories when the tree has
+// include patterns.  Errors are ignored; walkTree reports them.
+func treeIncludedDirs(fsys fs.FS, srcBaseDir string, tree Tree) map[string]bool {
+	ret := make(map[string]bool)
+	_ = fs.WalkDir(fsys, srcBaseDir, func(srcPath string, d fs.DirEntry, err error) error {
+		if err != nil || srcPath == srcBaseDir {
+			return nil
+		}
+		relPath := strings.TrimPrefix(srcPath, srcBaseDir+"/")
+		if treeGlobsMatch(tree.Exclude, relPath) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !treeGlobsMatch(tree.Include, relPath) {
+			return nil
+		}
+		for dir := slashpath.Dir(srcPath); dir != srcBaseDir && !ret[dir]; dir = slashpath.Dir(dir) {
+			ret[dir] = true
+		}
+		return nil
+	})
+	return ret
+}
+
+// treeGlobsMatch returns true if any of the glob patterns matches relPath,
+// a path relative to the root of a tree.  Patterns containing a slash are
+// matched against the whole path, and others against its last component.
+func treeGlobsMatch(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		subject := relPath
+		if strings.Contains(pattern, "/") {
+			pattern = strings.TrimPrefix(pattern, "/")
+		} else {
+			subject = slashpath.Base(relPath)
+		}
+		if ok, _ := slashpath.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (c Config) addMountUnits(config *types.Config, ts *translate.TranslationSet) {
 	if len(c.Storage.Filesystems) == 0 {
 		return
@@ -33,6 +33,7 @@ import (
 	"github.com/coreos/ignition/v2/config/v3_4_experimental/types"
 	"github.com/coreos/vcontext/path"
 	"github.com/coreos/vcontext/report"
+	"github.com/coreos/vcontext/tree"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -1497,6 +1498,174 @@ func TestTranslateTree(t *testing.T) {
 				}
 			},
 		},
+		// include, exclude, default ownership, and overrides
+		{
+			dirFiles: map[string]os.FileMode{
+				"tree/.git/config":       0644,
+				"tree/bin/tool":          0644,
+				"tree/etc/app.conf":      0644,
+				"tree/etc/app.conf~":     0644,
+				"tree/etc/secret.key":    0644,
+				"tree/etc/overridden":    0644,
+				"tree/home/core/.bashrc": 0644,
+				"tree/README":            0644,
+			},
+			dirLinks: map[string]string{
+				"tree/etc/link": "app.conf",
+			},
+			inTrees: []Tree{
+				{
+					Local:   "tree",
+					Include: []string{"/bin/*", "etc/*", ".bashrc"},
+					Exclude: []string{".git", "*~"},
+					User: NodeUser{
+						Name: util.StrToPtr("root"),
+					},
+					Group: NodeGroup{
+						ID: util.IntToPtr(0),
+					},
+					Overrides: []TreeOverride{
+						{
+							Match: "bin/*",
+							Mode:  util.IntToPtr(0755),
+						},
+						{
+							Match: "*.key",
+							Mode:  util.IntToPtr(0600),
+						},
+						{
+							Match: "/home/core/*",
+							User: NodeUser{
+								Name: util.StrToPtr("core"),
+							},
+							Group: NodeGroup{
+								Name: util.StrToPtr("core"),
+							},
+						},
+						{
+							Match: "secret.key",
+							Mode:  util.IntToPtr(0400),
+						},
+					},
+				},
+			},
+			inFiles: []File{
+				{
+					Path: "/etc/overridden",
+					User: NodeUser{
+						Name: util.StrToPtr("bovik"),
+					},
+				},
+			},
+			outFiles: []types.File{
+				{
+					Node: types.Node{
+						Path: "/etc/overridden",
+						User: types.NodeUser{
+							Name: util.StrToPtr("bovik"),
+						},
+						Group: types.NodeGroup{
+							ID: util.IntToPtr(0),
+						},
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source:      util.StrToPtr("data:,tree%2Fetc%2Foverridden"),
+							Compression: util.StrToPtr(""),
+						},
+						Mode: util.IntToPtr(0644),
+					},
+				},
+				{
+					Node: types.Node{
+						Path: "/bin/tool",
+						User: types.NodeUser{
+							Name: util.StrToPtr("root"),
+						},
+						Group: types.NodeGroup{
+							ID: util.IntToPtr(0),
+						},
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source:      util.StrToPtr("data:,tree%2Fbin%2Ftool"),
+							Compression: util.StrToPtr(""),
+						},
+						Mode: util.IntToPtr(0755),
+					},
+				},
+				{
+					Node: types.Node{
+						Path: "/etc/app.conf",
+						User: types.NodeUser{
+							Name: util.StrToPtr("root"),
+						},
+						Group: types.NodeGroup{
+							ID: util.IntToPtr(0),
+						},
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source:      util.StrToPtr("data:,tree%2Fetc%2Fapp.conf"),
+							Compression: util.StrToPtr(""),
+						},
+						Mode: util.IntToPtr(0644),
+					},
+				},
+				{
+					Node: types.Node{
+						Path: "/etc/secret.key",
+						User: types.NodeUser{
+							Name: util.StrToPtr("root"),
+						},
+						Group: types.NodeGroup{
+							ID: util.IntToPtr(0),
+						},
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source:      util.StrToPtr("data:,tree%2Fetc%2Fsecret.key"),
+							Compression: util.StrToPtr(""),
+						},
+						Mode: util.IntToPtr(0400),
+					},
+				},
+				{
+					Node: types.Node{
+						Path: "/home/core/.bashrc",
+						User: types.NodeUser{
+							Name: util.StrToPtr("core"),
+						},
+						Group: types.NodeGroup{
+							Name: util.StrToPtr("core"),
+						},
+					},
+					FileEmbedded1: types.FileEmbedded1{
+						Contents: types.Resource{
+							Source:      util.StrToPtr("data:,tree%2Fhome%2Fcore%2F.bashrc"),
+							Compression: util.StrToPtr(""),
+						},
+						Mode: util.IntToPtr(0644),
+					},
+				},
+			},
+			outLinks: []types.Link{
+				{
+					Node: types.Node{
+						Path: "/etc/link",
+						User: types.NodeUser{
+							Name: util.StrToPtr("root"),
+						},
+						Group: types.NodeGroup{
+							ID: util.IntToPtr(0),
+						},
+					},
+					LinkEmbedded1: types.LinkEmbedded1{
+						Target: util.StrToPtr("app.conf"),
+					},
+				},
+			},
+		},
 		// local is not a directory
 		{
 			dirFiles: map[string]os.FileMode{
@@ -1602,6 +1771,324 @@ func TestTranslateTree(t *testing.T) {
 	}
 }
 
+// TestTranslateTreeOverrides checks that translations of tree node
+// attributes point to the tree field or override that set them.
+func TestTranslateTreeOverrides(t *testing.T) {
+	filesDir := t.TempDir()
+	for _, name := range []string{"tree/file", "tree/secret.key"} {
+		p := filepath.Join(filesDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := Config{
+		Storage: Storage{
+			Trees: []Tree{
+				{
+					Local: "tree",
+					User: NodeUser{
+						Name: util.StrToPtr("root"),
+					},
+					Overrides: []TreeOverride{
+						{
+							Match: "*.key",
+							Mode:  util.IntToPtr(0600),
+							Group: NodeGroup{
+								Name: util.StrToPtr("wheel"),
+							},
+						},
+						{
+							Match: "secret.*",
+							User: NodeUser{
+								Name: util.StrToPtr("core"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	_, translations, r := config.ToIgn3_4Unvalidated(common.TranslateOptions{
+		FilesDir: filesDir,
+	})
+	assert.Equal(t, "", r.String(), "non-empty report")
+
+	expected := map[string]string{
+		"$.storage.files.0.mode":       "$.storage.trees.0",
+		"$.storage.files.0.user.name":  "$.storage.trees.0.user",
+		"$.storage.files.1.mode":       "$.storage.trees.0.overrides.0.mode",
+		"$.storage.files.1.group.name": "$.storage.trees.0.overrides.0.group",
+		"$.storage.files.1.user.name":  "$.storage.trees.0.overrides.1.user",
+	}
+	for to, from := range expected {
+		translation, ok := translations.Set[to]
+		if assert.True(t, ok, "missing translation for %s", to) {
+			assert.Equal(t, from, translation.From.String(), "bad translation for %s", to)
+		}
+	}
+}
+
+func TestTranslateTreePreserve(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links and mode bits are not supported on Windows")
+	}
+	filesDir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{
+		"tree/empty": 0700,
+		"tree/sub":   0750,
+	} {
+		if err := os.MkdirAll(filepath.Join(filesDir, filepath.FromSlash(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filepath.Join(filesDir, filepath.FromSlash(name)), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, mode := range map[string]os.FileMode{
+		"tree/odd":      0602,
+		"tree/sub/file": 0755 | os.ModeSetuid,
+	} {
+		p := filepath.Join(filesDir, filepath.FromSlash(name))
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(filepath.Join(filesDir, "tree", "odd"), filepath.Join(filesDir, "tree", "sub", "hard")); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{
+		Storage: Storage{
+			Trees: []Tree{
+				{
+					Local: "tree",
+					Preserve: TreePreserve{
+						Directories: util.BoolToPtr(true),
+						Hardlinks:   util.BoolToPtr(true),
+						Mode:        util.BoolToPtr(true),
+					},
+					Overrides: []TreeOverride{
+						{
+							Match: "sub",
+							Mode:  util.IntToPtr(0755),
+						},
+					},
+				},
+			},
+		},
+	}
+	result, translations, r := config.ToIgn3_4Unvalidated(common.TranslateOptions{
+		FilesDir: filesDir,
+	})
+	expectedReport := report.Report{
+		Entries: []report.Entry{
+			{
+				Kind:    report.Warn,
+				Message: fmt.Sprintf("%s: %v (0602)", "tree/odd", common.ErrUnusualMode),
+			},
+		},
+	}
+	if assert.Len(t, r.Entries, 1, "bad report: %v", r) {
+		expectedReport.Entries[0].Context = r.Entries[0].Context
+	}
+	assert.Equal(t, expectedReport, r, "bad report")
+	assert.Equal(t, []types.Directory{
+		{
+			Node: types.Node{
+				Path: "/empty",
+			},
+			DirectoryEmbedded1: types.DirectoryEmbedded1{
+				Mode: util.IntToPtr(0700),
+			},
+		},
+		{
+			Node: types.Node{
+				Path: "/sub",
+			},
+			DirectoryEmbedded1: types.DirectoryEmbedded1{
+				Mode: util.IntToPtr(0755),
+			},
+		},
+	}, result.Storage.Directories, "bad directories")
+	if assert.Len(t, result.Storage.Files, 2, "bad files") {
+		assert.Equal(t, "/odd", result.Storage.Files[0].Path, "bad file path")
+		assert.Equal(t, util.IntToPtr(0602), result.Storage.Files[0].Mode, "bad file mode")
+		assert.Equal(t, "/sub/file", result.Storage.Files[1].Path, "bad file path")
+		assert.Equal(t, util.IntToPtr(04755), result.Storage.Files[1].Mode, "bad file mode")
+	}
+	assert.Equal(t, []types.Link{
+		{
+			Node: types.Node{
+				Path: "/sub/hard",
+			},
+			LinkEmbedded1: types.LinkEmbedded1{
+				Hard:   util.BoolToPtr(true),
+				Target: util.StrToPtr("/odd"),
+			},
+		},
+	}, result.Storage.Links, "bad links")
+
+	expected := map[string]string{
+		"$.storage.directories.0":      "$.storage.trees.0",
+		"$.storage.directories.0.mode": "$.storage.trees.0.preserve.mode",
+		"$.storage.directories.1.mode": "$.storage.trees.0.overrides.0.mode",
+		"$.storage.files.1.mode":       "$.storage.trees.0.preserve.mode",
+		"$.storage.links.0.hard":       "$.storage.trees.0.preserve.hardlinks",
+	}
+	for to, from := range expected {
+		translation, ok := translations.Set[to]
+		if assert.True(t, ok, "missing translation for %s", to) {
+			assert.Equal(t, from, translation.From.String(), "bad translation for %s", to)
+		}
+	}
+}
+
+// TestTranslateTreePreserveInclude checks that directories without
+// included contents aren't preserved.
+func TestTranslateTreePreserveInclude(t *testing.T) {
+	filesDir := t.TempDir()
+	for _, name := range []string{"tree/empty", "tree/conf/nested", "tree/docs/sub"} {
+		if err := os.MkdirAll(filepath.Join(filesDir, filepath.FromSlash(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"tree/conf/nested/a.conf", "tree/docs/sub/README", "tree/skip/b.conf"} {
+		p := filepath.Join(filesDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := Config{
+		Storage: Storage{
+			Trees: []Tree{
+				{
+					Local:   "tree",
+					Include: []string{"*.conf"},
+					Exclude: []string{"skip"},
+					Preserve: TreePreserve{
+						Directories: util.BoolToPtr(true),
+					},
+				},
+			},
+		},
+	}
+	result, _, r := config.ToIgn3_4Unvalidated(common.TranslateOptions{
+		FilesDir: filesDir,
+	})
+	assert.Equal(t, report.Report{}, r, "bad report")
+	var dirs []string
+	for _, dir := range result.Storage.Directories {
+		dirs = append(dirs, dir.Path)
+	}
+	assert.Equal(t, []string{"/conf", "/conf/nested"}, dirs, "bad directories")
+	if assert.Len(t, result.Storage.Files, 1, "bad files") {
+		assert.Equal(t, "/conf/nested/a.conf", result.Storage.Files[0].Path, "bad file path")
+	}
+}
+
+func TestTranslateTemplate(t *testing.T) {
+	filesDir := t.TempDir()
+	for name, contents := range map[string]string{
+		"vars.yaml":      "env: prod\nport: 80\n",
+		"app.conf":       "env={{.env}} port={{.port}}",
+		"tree/env":       "{{.env}}",
+		"broken/missing": "line 1\n{{.missing}}",
+		"broken/syntax":  "{{if .env}}",
+	} {
+		p := filepath.Join(filesDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := Config{
+		Storage: Storage{
+			Files: []File{
+				{
+					Path: "/app.conf",
+					Contents: Resource{
+						Local:             util.StrToPtr("app.conf"),
+						Template:          util.BoolToPtr(true),
+						TemplateData:      map[string]interface{}{"port": 8080},
+						TemplateDataLocal: util.StrToPtr("vars.yaml"),
+					},
+				},
+			},
+			Trees: []Tree{
+				{
+					Local:             "tree",
+					Template:          util.BoolToPtr(true),
+					TemplateDataLocal: util.StrToPtr("vars.yaml"),
+				},
+			},
+		},
+	}
+	options := common.TranslateOptions{
+		FilesDir: filesDir,
+	}
+	result, _, r := config.ToIgn3_4Unvalidated(options)
+	assert.Equal(t, "", r.String(), "non-empty report")
+	if assert.Len(t, result.Storage.Files, 2, "bad files") {
+		assert.Equal(t, util.StrToPtr("data:,env%3Dprod%20port%3D8080"), result.Storage.Files[0].Contents.Source, "bad templated file")
+		assert.Equal(t, "/env", result.Storage.Files[1].Path, "bad tree file path")
+		assert.Equal(t, util.StrToPtr("data:,prod"), result.Storage.Files[1].Contents.Source, "bad templated tree file")
+	}
+
+	// errors name the template file and line
+	config = Config{
+		Storage: Storage{
+			Trees: []Tree{
+				{
+					Local:    "broken",
+					Template: util.BoolToPtr(true),
+				},
+			},
+		},
+	}
+	_, _, r = config.ToIgn3_4Unvalidated(options)
+	if assert.Len(t, r.Entries, 2, "bad report: %v", r) {
+		assert.Contains(t, r.Entries[0].Message, "broken/missing:2:", "bad error")
+		assert.Equal(t, path.New("yaml", "storage", "trees", 0), r.Entries[0].Context, "bad context")
+		assert.Equal(t, tree.Marker{StartP: &tree.Pos{Line: 2, Column: 3}}, r.Entries[0].Marker, "bad marker")
+		assert.Contains(t, r.Entries[1].Message, "broken/syntax:1:", "bad error")
+		assert.Equal(t, path.New("yaml", "storage", "trees", 0), r.Entries[1].Context, "bad context")
+		assert.Equal(t, tree.Marker{StartP: &tree.Pos{Line: 1}}, r.Entries[1].Marker, "bad marker")
+	}
+
+	config = Config{
+		Storage: Storage{
+			Files: []File{
+				{
+					Path: "/missing",
+					Contents: Resource{
+						Local:    util.StrToPtr("broken/missing"),
+						Template: util.BoolToPtr(true),
+					},
+				},
+			},
+		},
+	}
+	_, _, r = config.ToIgn3_4Unvalidated(options)
+	if assert.Len(t, r.Entries, 1, "bad report: %v", r) {
+		assert.Contains(t, r.Entries[0].Message, "broken/missing:2:", "bad error")
+		assert.Equal(t, path.New("yaml", "storage", "files", 0, "contents", "local"), r.Entries[0].Context, "bad context")
+		assert.Equal(t, tree.Marker{StartP: &tree.Pos{Line: 2, Column: 3}}, r.Entries[0].Marker, "bad marker")
+	}
+}
+
 // TestTranslateIgnition tests translating the ct config.ignition to the ignition config.ignition section.
 // It ensures that the version is set as well.
 func TestTranslateIgnition(t *testing.T) {
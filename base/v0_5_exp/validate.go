@@ -15,6 +15,8 @@
 package v0_5_exp
 
 import (
+	slashpath "path"
+
 	baseutil "github.com/coreos/butane/base/util"
 	"github.com/coreos/butane/config/common"
 
@@ -38,9 +40,42 @@ func (rs Resource) Validate(c path.ContextPath) (r report.Report) {
 		sources++
 		field = "source"
 	}
+	structured := false
+	for _, f := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"json", rs.JSON},
+		{"yaml", rs.YAML},
+		{"toml", rs.TOML},
+		{"ini", rs.INI},
+	} {
+		if f.value != nil {
+			sources++
+			field = f.name
+			structured = true
+		}
+	}
 	if sources > 1 {
-		r.AddOnError(c.Append(field), common.ErrTooManyResourceSources)
+		if structured {
+			r.AddOnError(c.Append(field), common.ErrTooManyContentsSources)
+		} else {
+			r.AddOnError(c.Append(field), common.ErrTooManyResourceSources)
+		}
+	}
+	if rs.JSON != nil {
+		r.Merge(baseutil.ValidateJSON(c.Append("json"), rs.JSON))
+	}
+	if rs.TOML != nil {
+		r.Merge(baseutil.ValidateTOML(c.Append("toml"), rs.TOML))
+	}
+	if rs.INI != nil {
+		r.Merge(baseutil.ValidateINI(c.Append("ini"), rs.INI))
+	}
+	if util.IsTrue(rs.Template) && rs.Local == nil {
+		r.AddOnError(c.Append("template"), common.ErrTemplateNoLocal)
 	}
+	r.Merge(validateTemplateData(c, rs.Template, rs.TemplateData, rs.TemplateDataLocal))
 	return
 }
 
@@ -74,5 +109,44 @@ func (t Tree) Validate(c path.ContextPath) (r report.Report) {
 	if t.Local == "" {
 		r.AddOnError(c, common.ErrTreeNoLocal)
 	}
+	for i, pattern := range t.Include {
+		r.AddOnError(c.Append("include", i), validateTreeGlob(pattern))
+	}
+	for i, pattern := range t.Exclude {
+		r.AddOnError(c.Append("exclude", i), validateTreeGlob(pattern))
+	}
+	r.Merge(validateTemplateData(c, t.Template, t.TemplateData, t.TemplateDataLocal))
 	return
 }
+
+func (o TreeOverride) Validate(c path.ContextPath) (r report.Report) {
+	if o.Match == "" {
+		r.AddOnError(c.Append("match"), common.ErrTreeOverrideNoMatch)
+	} else {
+		r.AddOnError(c.Append("match"), validateTreeGlob(o.Match))
+	}
+	if o.Mode != nil {
+		r.AddOnWarn(c.Append("mode"), baseutil.CheckForDecimalMode(*o.Mode, false))
+	}
+	return
+}
+
+func validateTemplateData(c path.ContextPath, template *bool, data map[string]interface{}, dataLocal *string) (r report.Report) {
+	if util.IsTrue(template) {
+		return
+	}
+	if data != nil {
+		r.AddOnError(c.Append("template_data"), common.ErrTemplateDataNoTemplate)
+	}
+	if dataLocal != nil {
+		r.AddOnError(c.Append("template_data_local"), common.ErrTemplateDataNoTemplate)
+	}
+	return
+}
+
+func validateTreeGlob(pattern string) error {
+	if _, err := slashpath.Match(pattern, ""); err != nil {
+		return common.ErrTreeGlob
+	}
+	return nil
+}
@@ -125,6 +125,60 @@ func TestValidateResource(t *testing.T) {
 			common.ErrTooManyResourceSources,
 			path.New("yaml", "source"),
 		},
+		// structured contents
+		{
+			Resource{
+				TOML: map[string]interface{}{"a": 1},
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// structured + inline, invalid
+		{
+			Resource{
+				Inline: util.StrToPtr("hello"),
+				JSON:   map[string]interface{}{"a": 1},
+			},
+			common.ErrTooManyContentsSources,
+			path.New("yaml", "json"),
+		},
+		// malformed structured contents, invalid
+		{
+			Resource{
+				INI: []interface{}{"a"},
+			},
+			common.ErrStructuredNotMapping,
+			path.New("yaml", "ini"),
+		},
+		// template + local
+		{
+			Resource{
+				Local:             util.StrToPtr("hello"),
+				Template:          util.BoolToPtr(true),
+				TemplateData:      map[string]interface{}{"a": "b"},
+				TemplateDataLocal: util.StrToPtr("data.yaml"),
+			},
+			nil,
+			path.New("yaml"),
+		},
+		// template + inline, invalid
+		{
+			Resource{
+				Inline:   util.StrToPtr("hello"),
+				Template: util.BoolToPtr(true),
+			},
+			common.ErrTemplateNoLocal,
+			path.New("yaml", "template"),
+		},
+		// template data without template, invalid
+		{
+			Resource{
+				Local:        util.StrToPtr("hello"),
+				TemplateData: map[string]interface{}{"a": "b"},
+			},
+			common.ErrTemplateDataNoTemplate,
+			path.New("yaml", "template_data"),
+		},
 	}
 
 	for i, test := range tests {
@@ -139,12 +193,73 @@ func TestValidateResource(t *testing.T) {
 
 func TestValidateTree(t *testing.T) {
 	tests := []struct {
-		in  Tree
-		out error
+		in      Tree
+		out     error
+		errPath path.ContextPath
+	}{
+		{
+			in:      Tree{},
+			out:     common.ErrTreeNoLocal,
+			errPath: path.New("yaml"),
+		},
+		{
+			in: Tree{
+				Local:   "tree",
+				Include: []string{"*.conf", "/etc/*"},
+				Exclude: []string{".git", "*~"},
+			},
+		},
+		{
+			in: Tree{
+				Local:   "tree",
+				Include: []string{"*.conf", "[a-"},
+			},
+			out:     common.ErrTreeGlob,
+			errPath: path.New("yaml", "include", 1),
+		},
+		{
+			in: Tree{
+				Local:   "tree",
+				Exclude: []string{"\\"},
+			},
+			out:     common.ErrTreeGlob,
+			errPath: path.New("yaml", "exclude", 0),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
+			actual := test.in.Validate(path.New("yaml"))
+			expected := report.Report{}
+			expected.AddOnError(test.errPath, test.out)
+			assert.Equal(t, expected, actual, "bad report")
+		})
+	}
+}
+
+func TestValidateTreeOverride(t *testing.T) {
+	tests := []struct {
+		in      TreeOverride
+		out     error
+		errPath path.ContextPath
 	}{
 		{
-			in:  Tree{},
-			out: common.ErrTreeNoLocal,
+			in: TreeOverride{
+				Match: "*.key",
+				Mode:  util.IntToPtr(0600),
+			},
+		},
+		{
+			in:      TreeOverride{},
+			out:     common.ErrTreeOverrideNoMatch,
+			errPath: path.New("yaml", "match"),
+		},
+		{
+			in: TreeOverride{
+				Match: "[",
+			},
+			out:     common.ErrTreeGlob,
+			errPath: path.New("yaml", "match"),
 		},
 	}
 
@@ -152,10 +267,19 @@ func TestValidateTree(t *testing.T) {
 		t.Run(fmt.Sprintf("validate %d", i), func(t *testing.T) {
 			actual := test.in.Validate(path.New("yaml"))
 			expected := report.Report{}
-			expected.AddOnError(path.New("yaml"), test.out)
+			expected.AddOnError(test.errPath, test.out)
 			assert.Equal(t, expected, actual, "bad report")
 		})
 	}
+
+	// decimal mode
+	actual := TreeOverride{
+		Match: "*",
+		Mode:  util.IntToPtr(600),
+	}.Validate(path.New("yaml"))
+	expected := report.Report{}
+	expected.AddOnWarn(path.New("yaml", "mode"), common.ErrDecimalMode)
+	assert.Equal(t, expected, actual, "bad report")
 }
 
 func TestValidateFileMode(t *testing.T) {
@@ -0,0 +1,438 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	ignerrors "github.com/coreos/ignition/v2/config/shared/errors"
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+)
+
+// UnknownCode is the code of report entries that weren't produced from
+// an error with a registered code, such as YAML syntax errors.
+const UnknownCode = "BU0000"
+
+// Codes of report entries that aren't produced from an error value.
+const (
+	NoInstallSectionCode = "BU2072"
+	UnusedKeyCode        = "BU3001"
+	ContextMismatchCode  = "BU3002"
+)
+
+// Codes BU9000 through BU9999 are reserved for translators registered
+// from outside this module.
+const (
+	reservedCodeMin = 9000
+	reservedCodeMax = 9999
+)
+
+var (
+	// codes maps errors to stable codes for tools that process reports.
+	// Codes must never be reused or renumbered; give new errors the
+	// next unused code in their range.
+	//
+	// BU1xxx: errors in this package
+	// BU2xxx: Ignition validation errors
+	// BU3xxx: entries not produced from an error value
+	codes = map[error]string{
+		// common field parsing
+		ErrNoVariant:      "BU1001",
+		ErrInvalidVersion: "BU1002",
+
+		// high-level errors for fatal reports
+		ErrInvalidSourceConfig:    "BU1003",
+		ErrInvalidGeneratedConfig: "BU1004",
+
+		// deprecated variant/version
+		ErrRhcosVariantDeprecated: "BU1005",
+
+		// resources and trees
+		ErrTooManyResourceSources: "BU1006",
+		ErrFilesDirEscape:         "BU1007",
+		ErrFileType:               "BU1008",
+		ErrNodeExists:             "BU1009",
+		ErrNoFilesDir:             "BU1010",
+		ErrTreeNotDirectory:       "BU1011",
+		ErrTreeNoLocal:            "BU1012",
+		ErrTreeGlob:               "BU1013",
+		ErrTreeOverrideNoMatch:    "BU1014",
+		ErrReadLinkUnsupported:    "BU1015",
+		ErrTemplateNoLocal:        "BU1016",
+		ErrTemplateDataNoTemplate: "BU1017",
+		ErrTemplateDataNotMapping: "BU1018",
+
+		// structured resource contents
+		ErrTooManyContentsSources: "BU1019",
+		ErrStructuredNotMapping:   "BU1020",
+		ErrStructuredKeyNotString: "BU1021",
+		ErrStructuredNull:         "BU1022",
+		ErrINIKey:                 "BU1023",
+		ErrINIMultiline:           "BU1024",
+		ErrININesting:             "BU1025",
+
+		// remote resources
+		ErrRemoteHashMismatch: "BU1026",
+		ErrRemoteCertificate:  "BU1027",
+
+		// secret scanning
+		ErrSecretPrivateKey:    "BU1028",
+		ErrSecretCredential:    "BU1029",
+		ErrSecretHighEntropy:   "BU1030",
+		ErrSecretWorldReadable: "BU1031",
+
+		// lint
+		ErrUnusedSuppression: "BU1032",
+
+		// config merging
+		ErrMergeNotMapping:      "BU1033",
+		ErrMergeVersionMismatch: "BU1034",
+		ErrMergeIncludeLocal:    "BU1035",
+		ErrMergeCycle:           "BU1036",
+
+		// filesystem nodes
+		ErrDecimalMode: "BU1037",
+		ErrUnusualMode: "BU1038",
+
+		// mount units
+		ErrMountUnitNoPath:   "BU1039",
+		ErrMountUnitNoFormat: "BU1040",
+
+		// boot device
+		ErrUnknownBootDeviceLayout: "BU1041",
+		ErrTooFewMirrorDevices:     "BU1042",
+
+		// partition
+		ErrWrongPartitionNumber: "BU1043",
+
+		// MachineConfigs
+		ErrFieldElided:            "BU1044",
+		ErrNameRequired:           "BU1045",
+		ErrRoleRequired:           "BU1046",
+		ErrInvalidKernelType:      "BU1047",
+		ErrBtrfsSupport:           "BU1048",
+		ErrFilesystemNoneSupport:  "BU1049",
+		ErrDirectorySupport:       "BU1050",
+		ErrFileSchemeSupport:      "BU1051",
+		ErrFileAppendSupport:      "BU1052",
+		ErrFileCompressionSupport: "BU1053",
+		ErrFileSpecialModeSupport: "BU1054",
+		ErrLinkSupport:            "BU1055",
+		ErrGroupSupport:           "BU1056",
+		ErrUserFieldSupport:       "BU1057",
+		ErrUserNameSupport:        "BU1058",
+		ErrKernelArgumentSupport:  "BU1059",
+
+		// Storage
+		ErrClevisSupport: "BU1060",
+
+		// Extensions
+		ErrExtensionNameRequired: "BU1061",
+
+		// Ignition validation
+		// Parsing / general errors
+		ignerrors.ErrInvalid:   "BU2001",
+		ignerrors.ErrEmpty:     "BU2002",
+		ignerrors.ErrDuplicate: "BU2003",
+
+		// Ignition section errors
+		ignerrors.ErrInvalidVersion: "BU2004",
+		ignerrors.ErrUnknownVersion: "BU2005",
+
+		ignerrors.ErrDeprecated:         "BU2006",
+		ignerrors.ErrCompressionInvalid: "BU2007",
+
+		// Storage section errors
+		ignerrors.ErrFileUsedSymlink:           "BU2008",
+		ignerrors.ErrDirectoryUsedSymlink:      "BU2009",
+		ignerrors.ErrLinkUsedSymlink:           "BU2010",
+		ignerrors.ErrLinkTargetRequired:        "BU2011",
+		ignerrors.ErrHardLinkToDirectory:       "BU2012",
+		ignerrors.ErrDiskDeviceRequired:        "BU2013",
+		ignerrors.ErrPartitionNumbersCollide:   "BU2014",
+		ignerrors.ErrPartitionsOverlap:         "BU2015",
+		ignerrors.ErrPartitionsMisaligned:      "BU2016",
+		ignerrors.ErrOverwriteAndNilSource:     "BU2017",
+		ignerrors.ErrVerificationAndNilSource:  "BU2018",
+		ignerrors.ErrFilesystemInvalidFormat:   "BU2019",
+		ignerrors.ErrLabelNeedsFormat:          "BU2020",
+		ignerrors.ErrFormatNilWithOthers:       "BU2021",
+		ignerrors.ErrExt4LabelTooLong:          "BU2022",
+		ignerrors.ErrBtrfsLabelTooLong:         "BU2023",
+		ignerrors.ErrXfsLabelTooLong:           "BU2024",
+		ignerrors.ErrSwapLabelTooLong:          "BU2025",
+		ignerrors.ErrVfatLabelTooLong:          "BU2026",
+		ignerrors.ErrLuksLabelTooLong:          "BU2027",
+		ignerrors.ErrLuksNameContainsSlash:     "BU2028",
+		ignerrors.ErrInvalidLuksKeyFile:        "BU2029",
+		ignerrors.ErrClevisPinRequired:         "BU2030",
+		ignerrors.ErrUnknownClevisPin:          "BU2031",
+		ignerrors.ErrClevisConfigRequired:      "BU2032",
+		ignerrors.ErrClevisCustomWithOthers:    "BU2033",
+		ignerrors.ErrTangThumbprintRequired:    "BU2034",
+		ignerrors.ErrFileIllegalMode:           "BU2035",
+		ignerrors.ErrModeSpecialBits:           "BU2036",
+		ignerrors.ErrBothIDAndNameSet:          "BU2037",
+		ignerrors.ErrLabelTooLong:              "BU2038",
+		ignerrors.ErrDoesntMatchGUIDRegex:      "BU2039",
+		ignerrors.ErrLabelContainsColon:        "BU2040",
+		ignerrors.ErrNoPath:                    "BU2041",
+		ignerrors.ErrPathRelative:              "BU2042",
+		ignerrors.ErrDirtyPath:                 "BU2043",
+		ignerrors.ErrRaidLevelRequired:         "BU2044",
+		ignerrors.ErrSparesUnsupportedForLevel: "BU2045",
+		ignerrors.ErrUnrecognizedRaidLevel:     "BU2046",
+		ignerrors.ErrRaidDevicesRequired:       "BU2047",
+		ignerrors.ErrShouldNotExistWithOthers:  "BU2048",
+		ignerrors.ErrZeroesWithShouldNotExist:  "BU2049",
+		ignerrors.ErrNeedLabelOrNumber:         "BU2050",
+		ignerrors.ErrDuplicateLabels:           "BU2051",
+		ignerrors.ErrInvalidProxy:              "BU2052",
+		ignerrors.ErrInsecureProxy:             "BU2053",
+
+		// Systemd section errors
+		ignerrors.ErrInvalidSystemdExt:       "BU2054",
+		ignerrors.ErrInvalidSystemdDropinExt: "BU2055",
+		ignerrors.ErrNoSystemdExt:            "BU2056",
+		ignerrors.ErrInvalidInstantiatedUnit: "BU2057",
+
+		// Misc errors
+		ignerrors.ErrSourceRequired:                  "BU2058",
+		ignerrors.ErrInvalidScheme:                   "BU2059",
+		ignerrors.ErrInvalidUrl:                      "BU2060",
+		ignerrors.ErrInvalidHTTPHeader:               "BU2061",
+		ignerrors.ErrEmptyHTTPHeaderName:             "BU2062",
+		ignerrors.ErrUnsupportedSchemeForHTTPHeaders: "BU2063",
+		ignerrors.ErrHashMalformed:                   "BU2064",
+		ignerrors.ErrHashWrongSize:                   "BU2065",
+		ignerrors.ErrHashUnrecognized:                "BU2066",
+		ignerrors.ErrEngineConfiguration:             "BU2067",
+
+		// AWS S3 specific errors
+		ignerrors.ErrInvalidS3ARN:             "BU2068",
+		ignerrors.ErrInvalidS3ObjectVersionId: "BU2069",
+
+		// Obsolete errors, left here for ABI compatibility
+		ignerrors.ErrFilePermissionsUnset:      "BU2070",
+		ignerrors.ErrDirectoryPermissionsUnset: "BU2071",
+	}
+
+	// messageCodes are codes for report entries that are produced from
+	// formatted messages rather than error values, matched by message
+	// fragment.  Butane attaches these codes when it creates the
+	// entries; the fragments identify the same messages from Ignition
+	// and document the codes.
+	messageCodes = []struct {
+		fragment string
+		code     string
+	}{
+		// Ignition's NewNoInstallSectionError, without the unit name
+		{formatFragment(ignerrors.NewNoInstallSectionError), NoInstallSectionCode},
+		// unused keys, from Butane and Ignition validation
+		{"Unused key ", UnusedKeyCode},
+		// Butane and Ignition context tree mismatch
+		{"context tree does not match content tree", ContextMismatchCode},
+	}
+
+	// codesLock guards codes so translators can register their codes
+	// from init functions while other translations are in progress
+	codesLock sync.RWMutex
+)
+
+// RegisterCode assigns a code to an error produced by a translator
+// registered from outside this module.  The code must be in the reserved
+// range BU9000 through BU9999.  It panics if the code is invalid or
+// either the error or the code is already registered.
+func RegisterCode(err error, code string) {
+	var n int
+	if _, scanErr := fmt.Sscanf(code, "BU%04d", &n); scanErr != nil || len(code) != 6 || n < reservedCodeMin || n > reservedCodeMax {
+		panic(fmt.Sprintf("error code %q is outside the reserved range BU%04d-BU%04d", code, reservedCodeMin, reservedCodeMax))
+	}
+	codesLock.Lock()
+	defer codesLock.Unlock()
+	if _, ok := codes[err]; ok {
+		panic("tried to reregister existing error")
+	}
+	for _, existing := range codes {
+		if existing == code {
+			panic("tried to reregister existing error code")
+		}
+	}
+	codes[err] = code
+}
+
+// Codes returns every registered code and the message, or for entries
+// not produced from an error value the message fragment, that it
+// identifies, sorted by code.
+func Codes() []Code {
+	codesLock.RLock()
+	defer codesLock.RUnlock()
+	ret := make([]Code, 0, len(codes)+len(messageCodes))
+	for err, code := range codes {
+		ret = append(ret, Code{Code: code, Message: err.Error()})
+	}
+	for _, mc := range messageCodes {
+		ret = append(ret, Code{Code: mc.code, Message: mc.fragment})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Code < ret[j].Code
+	})
+	return ret
+}
+
+// Code is a registered error code.
+type Code struct {
+	Code    string
+	Message string
+}
+
+// ErrorCode returns the code of err or of an error it wraps, or
+// UnknownCode if neither has a registered code.
+func ErrorCode(err error) string {
+	if err == nil {
+		return UnknownCode
+	}
+	codesLock.RLock()
+	for e, code := range codes {
+		if errors.Is(err, e) {
+			codesLock.RUnlock()
+			return code
+		}
+	}
+	codesLock.RUnlock()
+	return MessageCode(err.Error())
+}
+
+// CodedKind is the kind of a report entry that records the code of the
+// error that produced it.  It prints and is fatal like its report.Kind,
+// so entries that carry a code are formatted as before; use EntryKind
+// to compare the severity of entries.
+type CodedKind struct {
+	report.Kind
+	Code string
+}
+
+// AddOn adds err to the report with the specified kind, recording the
+// code of err in the entry.  Like report.Report.AddOn, it does nothing if
+// err is nil.
+func AddOn(r *report.Report, c path.ContextPath, err error, kind report.Kind) {
+	r.AddOn(c, err, CodedKind{
+		Kind: kind,
+		Code: ErrorCode(err),
+	})
+}
+
+// AddCodes returns a copy of the report in which every entry records a
+// code.  Entries that don't already have one are produced by code
+// outside Butane, such as Ignition validation, which only records the
+// message of the error; their codes are found with MessageCode.
+func AddCodes(r report.Report) report.Report {
+	var ret report.Report
+	ret.Merge(r)
+	for i, e := range ret.Entries {
+		if _, ok := e.Kind.(CodedKind); ok {
+			continue
+		}
+		ret.Entries[i].Kind = CodedKind{
+			Kind: EntryKind(e),
+			Code: MessageCode(e.Message),
+		}
+	}
+	return ret
+}
+
+// EntryKind returns the severity of a report entry, whether or not it
+// records a code.
+func EntryKind(e report.Entry) report.Kind {
+	switch k := e.Kind.(type) {
+	case CodedKind:
+		return k.Kind
+	case report.Kind:
+		return k
+	}
+	if e.Kind.IsFatal() {
+		return report.Error
+	}
+	return report.Warn
+}
+
+// SetEntryKind changes the severity of a report entry, keeping its code.
+func SetEntryKind(e *report.Entry, kind report.Kind) {
+	if k, ok := e.Kind.(CodedKind); ok {
+		k.Kind = kind
+		e.Kind = k
+	} else {
+		e.Kind = kind
+	}
+}
+
+// EntryCode returns the code recorded in a report entry.  For entries
+// created without a code, it falls back to finding the code from the
+// message; see MessageCode.
+func EntryCode(e report.Entry) string {
+	if k, ok := e.Kind.(CodedKind); ok {
+		return k.Code
+	}
+	return MessageCode(e.Message)
+}
+
+// MessageCode returns the code of the registered error whose message is
+// msg or, failing that, the longest registered message contained in msg,
+// since errors are often wrapped with details.  It returns UnknownCode
+// if no registered message matches.  It's a last resort for messages
+// whose error is no longer available; prefer ErrorCode.
+func MessageCode(msg string) string {
+	codesLock.RLock()
+	defer codesLock.RUnlock()
+	code := UnknownCode
+	longest := 0
+	for err, c := range codes {
+		m := err.Error()
+		if m == msg {
+			return c
+		}
+		// break ties by code so the result doesn't depend on map order
+		if strings.Contains(msg, m) && (len(m) > longest || (len(m) == longest && c < code)) {
+			code = c
+			longest = len(m)
+		}
+	}
+	for _, mc := range messageCodes {
+		if len(mc.fragment) > longest && strings.Contains(msg, mc.fragment) {
+			code = mc.code
+			longest = len(mc.fragment)
+		}
+	}
+	return code
+}
+
+// formatFragment returns the part of the message produced by an error
+// formatting function that follows its argument, so the message can be
+// matched whatever the argument.
+func formatFragment(format func(string) error) string {
+	const placeholder = "formatFragment"
+	msg := format(placeholder).Error()
+	i := strings.LastIndex(msg, placeholder)
+	if i < 0 {
+		panic("formatted message doesn't include its argument")
+	}
+	// skip the closing quote and space after the argument
+	return strings.TrimLeft(msg[i+len(placeholder):], `", `)
+}
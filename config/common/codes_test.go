@@ -0,0 +1,202 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	ignerrors "github.com/coreos/ignition/v2/config/shared/errors"
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestErrorCodesComplete checks that every error declared in errors.go
+// has a code.
+func TestErrorCodesComplete(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		msg, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		assert.Regexp(t, "^BU1", MessageCode(msg), "code of %q", msg)
+		return true
+	})
+	assert.NotZero(t, count)
+}
+
+func TestCodesUnique(t *testing.T) {
+	codeRe := regexp.MustCompile("^BU[0-9]{4}$")
+	codes := map[string]bool{}
+	messages := map[string]bool{}
+	for _, c := range Codes() {
+		assert.Regexp(t, codeRe, c.Code)
+		assert.False(t, codes[c.Code], "duplicate code %s", c.Code)
+		assert.False(t, messages[c.Message], "duplicate message %q", c.Message)
+		codes[c.Code] = true
+		messages[c.Message] = true
+	}
+}
+
+// TestCodesDocumented checks that docs/error-codes.md lists every code.
+func TestCodesDocumented(t *testing.T) {
+	doc, err := os.ReadFile("../../docs/error-codes.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range Codes() {
+		if c.Code >= fmt.Sprintf("BU%04d", reservedCodeMin) {
+			continue
+		}
+		row := fmt.Sprintf("| `%s` | %s |", c.Code, strings.ReplaceAll(strings.TrimSpace(c.Message), "|", "\\|"))
+		assert.Contains(t, string(doc), row)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		in  error
+		out string
+	}{
+		{ErrNoVariant, "BU1001"},
+		{ErrDecimalMode, "BU1037"},
+		{fmt.Errorf("/etc/shadow: %w (0644)", ErrSecretWorldReadable), "BU1031"},
+		{ignerrors.ErrModeSpecialBits, "BU2036"},
+		{ignerrors.NewNoInstallSectionError("foo.service"), "BU2072"},
+		// message of a wrapped error
+		{fmt.Errorf("%s", ErrFileAppendSupport.Error()), "BU1052"},
+		{errors.New("yaml: line 1: did not find expected key"), UnknownCode},
+		{nil, UnknownCode},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("error code %d", i), func(t *testing.T) {
+			assert.Equal(t, test.out, ErrorCode(test.in))
+		})
+	}
+}
+
+func TestEntryCode(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{ErrUnusualMode.Error(), "BU1038"},
+		{ErrDecimalMode.Error() + " [decimal-mode]", "BU1037"},
+		{"Unused key foo", "BU3001"},
+		{"Unused key foo; did you mean bar? [unused-key]", "BU3001"},
+		{"context tree does not match content tree at $.foo. Line and column reporting may be inconsistent. Unused keys may not be reported.", "BU3002"},
+		// the longest matching message wins
+		{"invalid config version (couldn't parse)", "BU2004"},
+		{"something else", UnknownCode},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("entry code %d", i), func(t *testing.T) {
+			assert.Equal(t, test.out, EntryCode(report.Entry{
+				Kind:    report.Warn,
+				Message: test.in,
+			}))
+		})
+	}
+}
+
+func TestCodedKind(t *testing.T) {
+	var r report.Report
+	AddOn(&r, path.New("yaml", "a"), fmt.Errorf("foo: %w", ErrDecimalMode), report.Warn)
+	AddOn(&r, path.New("yaml", "b"), nil, report.Warn)
+	r.AddOnError(path.New("yaml", "c"), ErrUnusualMode)
+	r.AddOnInfo(path.New("yaml", "d"), errors.New("something else"))
+	assert.Len(t, r.Entries, 3)
+
+	coded := AddCodes(r)
+	// original report is unchanged
+	assert.Equal(t, report.Error, r.Entries[1].Kind)
+	expected := []CodedKind{
+		{Kind: report.Warn, Code: "BU1037"},
+		{Kind: report.Error, Code: "BU1038"},
+		{Kind: report.Info, Code: UnknownCode},
+	}
+	for i, e := range coded.Entries {
+		assert.Equal(t, expected[i], e.Kind)
+		assert.Equal(t, expected[i].Code, EntryCode(e))
+		assert.Equal(t, expected[i].Kind, EntryKind(e))
+		// prints like its report.Kind
+		assert.Equal(t, expected[i].Kind.String(), e.Kind.String())
+		assert.Equal(t, expected[i].Kind.IsFatal(), e.Kind.IsFatal())
+	}
+	assert.Equal(t, r.String(), coded.String())
+
+	e := coded.Entries[0]
+	SetEntryKind(&e, report.Error)
+	assert.Equal(t, CodedKind{Kind: report.Error, Code: "BU1037"}, e.Kind)
+	e = r.Entries[2]
+	SetEntryKind(&e, report.Warn)
+	assert.Equal(t, report.Warn, e.Kind)
+}
+
+func TestRegisterCode(t *testing.T) {
+	errExternal := errors.New("external translator error")
+	RegisterCode(errExternal, "BU9001")
+	assert.Equal(t, "BU9001", ErrorCode(errExternal))
+	assert.Equal(t, "BU9001", ErrorCode(fmt.Errorf("foo: %w", errExternal)))
+	assert.Equal(t, "BU9001", MessageCode("foo: external translator error"))
+
+	tests := []struct {
+		err  error
+		code string
+	}{
+		// outside the reserved range
+		{errors.New("a"), "BU1001"},
+		{errors.New("b"), "BU8999"},
+		{errors.New("c"), "BU10000"},
+		{errors.New("d"), "XX9002"},
+		{errors.New("e"), "BU9x02"},
+		// duplicate code
+		{errors.New("f"), "BU9001"},
+		// duplicate error
+		{errExternal, "BU9002"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("register code %d", i), func(t *testing.T) {
+			assert.Panics(t, func() {
+				RegisterCode(test.err, test.code)
+			})
+		})
+	}
+}
@@ -14,10 +14,62 @@
 
 package common
 
+import (
+	"io/fs"
+	"time"
+
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/tree"
+)
+
+// DefaultRemoteTimeout is the time allowed for downloading each remote
+// resource if TranslateOptions.RemoteTimeout is zero.
+const DefaultRemoteTimeout = time.Minute
+
 type TranslateOptions struct {
-	FilesDir                  string // allow embedding local files relative to this directory
-	NoResourceAutoCompression bool   // skip automatic compression of inline/local resources
-	DebugPrintTranslations    bool   // report translations to stderr
+	FilesDir                  string             // allow embedding local files relative to this directory
+	FollowSymlinks            bool               // allow symlinks in FilesDir to point outside it
+	FS                        fs.FS              // if non-nil, read local files from this filesystem instead of FilesDir
+	NoResourceAutoCompression bool               // skip automatic compression of inline/local resources
+	EmbedRemote               bool               // download http(s) resources and embed them as data URLs
+	PinRemote                 bool               // add sha512 verification hashes to http(s) resources
+	RemoteCacheDir            string             // if non-empty, cache downloaded resources in this directory
+	RemoteTimeout             time.Duration      // limit on each download; defaults to DefaultRemoteTimeout
+	ScanSecrets               bool               // warn about embedded contents that appear to contain secrets
+	SecretAllowlist           []string           // paths or path.Match patterns of files that may contain secrets
+	DebugPrintTranslations    bool               // report translations to stderr
+	Record                    *TranslationRecord // if non-nil, receives details of the translation
+}
+
+// TranslationRecord receives details of a translation that aren't
+// included in the output config, for callers that want to relate the
+// output back to the Butane config.
+type TranslationRecord struct {
+	// Source is the context tree of the Butane config, for finding
+	// line and column numbers of source paths.
+	Source tree.Node
+	// Config is the Butane config that was translated, after merging
+	// any configs it includes, for finding the values of source paths.
+	Config []byte
+	// Translations maps paths in the output config to paths in the
+	// Butane config.
+	Translations translate.TranslationSet
+	// Files lists the local files, directories, and Butane configs read
+	// during translation, including ones that couldn't be read.  Paths
+	// are relative to FS if specified, and otherwise include FilesDir.
+	Files []string
+	// NoResourceAutoCompression is true if the translation didn't
+	// compress inline and local resources, either because the option
+	// was set or because the output format doesn't support compression.
+	NoResourceAutoCompression bool
+	// EmbeddedSources maps the paths of resource sources in the output
+	// config that were fetched and embedded as data URLs to their
+	// original URLs.
+	EmbeddedSources map[string]string
+
+	seenFiles map[string]struct{}
 }
 
 type TranslateBytesOptions struct {
@@ -25,3 +77,67 @@ type TranslateBytesOptions struct {
 	Pretty bool
 	Raw    bool // encode only the Ignition config, not any wrapper
 }
+
+// AddFile records that the translation read the specified local path.
+// It does nothing if r is nil, so translators can call it unconditionally.
+func (r *TranslationRecord) AddFile(path string) {
+	if r == nil {
+		return
+	}
+	if r.seenFiles == nil {
+		r.seenFiles = make(map[string]struct{})
+	}
+	if _, ok := r.seenFiles[path]; ok {
+		return
+	}
+	r.seenFiles[path] = struct{}{}
+	r.Files = append(r.Files, path)
+}
+
+// AddEmbeddedSource records that the resource source at the specified
+// output path was fetched from url and embedded.  It does nothing if r
+// is nil.
+func (r *TranslationRecord) AddEmbeddedSource(p path.ContextPath, url string) {
+	if r == nil {
+		return
+	}
+	if r.EmbeddedSources == nil {
+		r.EmbeddedSources = make(map[string]string)
+	}
+	r.EmbeddedSources[p.String()] = url
+}
+
+// SourceOf returns the path in the Butane config that produced the specified
+// output path, and its line number in the config (or 0 if unknown).  It
+// returns false if the output path has no known source.
+func (r *TranslationRecord) SourceOf(p path.ContextPath) (path.ContextPath, int64, bool) {
+	t, ok := r.Translations.Set[p.String()]
+	if !ok {
+		return path.ContextPath{}, 0, false
+	}
+	return t.From, r.SourceLine(t.From), true
+}
+
+// SourceLine returns the line number of the specified path in the Butane
+// config, or 0 if the path doesn't appear in the config.  For map keys,
+// the line of the key is returned.
+func (r *TranslationRecord) SourceLine(p path.ContextPath) int64 {
+	if r.Source == nil {
+		return 0
+	}
+	node, err := r.Source.Get(p)
+	if p.Len() > 0 {
+		if key, ok := p.Path[p.Len()-1].(string); ok {
+			if keyNode, keyErr := r.Source.Get(p.Copy().Pop().Append(tree.Key(key))); keyErr == nil {
+				node, err = keyNode, nil
+			}
+		}
+	}
+	if err != nil {
+		return 0
+	}
+	if marker := node.GetMarker(); marker.StartP != nil {
+		return marker.StartP.Line
+	}
+	return 0
+}
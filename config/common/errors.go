@@ -38,9 +38,44 @@ var (
 	ErrNoFilesDir             = errors.New("local file paths are relative to a files directory that must be specified with -d/--files-dir")
 	ErrTreeNotDirectory       = errors.New("root of tree must be a directory")
 	ErrTreeNoLocal            = errors.New("local is required")
+	ErrTreeGlob               = errors.New("invalid glob pattern")
+	ErrTreeOverrideNoMatch    = errors.New("match is required")
+	ErrReadLinkUnsupported    = errors.New("files filesystem does not support reading symlinks")
+	ErrTemplateNoLocal        = errors.New("template can only be used with local")
+	ErrTemplateDataNoTemplate = errors.New("template data can only be specified if template is true")
+	ErrTemplateDataNotMapping = errors.New("template data file must contain a YAML or JSON mapping")
+
+	// structured resource contents
+	ErrTooManyContentsSources = errors.New("only one of the following can be set: inline, local, source, json, yaml, toml, ini")
+	ErrStructuredNotMapping   = errors.New("contents must be a mapping")
+	ErrStructuredKeyNotString = errors.New("mapping keys must be strings")
+	ErrStructuredNull         = errors.New("null values are not supported in this format")
+	ErrINIKey                 = errors.New("INI keys and section names must be non-empty and cannot contain newlines, brackets, or '='")
+	ErrINIMultiline           = errors.New("INI values cannot contain newlines")
+	ErrININesting             = errors.New("INI values must be scalars or lists of scalars")
+
+	// remote resources
+	ErrRemoteHashMismatch = errors.New("remote contents don't match the verification hash")
+	ErrRemoteCertificate  = errors.New("certificate authority contains no PEM certificates")
+
+	// secret scanning
+	ErrSecretPrivateKey    = errors.New("contents contain a private key")
+	ErrSecretCredential    = errors.New("contents contain a credential")
+	ErrSecretHighEntropy   = errors.New("contents contain a high-entropy string that may be a secret")
+	ErrSecretWorldReadable = errors.New("allowlisted secret file is world-readable")
+
+	// lint
+	ErrUnusedSuppression = errors.New("butane:ignore comment didn't match any warning")
+
+	// config merging
+	ErrMergeNotMapping      = errors.New("config must be a YAML mapping")
+	ErrMergeVersionMismatch = errors.New("merged configs must have the same variant and version")
+	ErrMergeIncludeLocal    = errors.New("merge entries must specify a local config path")
+	ErrMergeCycle           = errors.New("config includes itself")
 
 	// filesystem nodes
 	ErrDecimalMode = errors.New("unreasonable mode would be reasonable if specified in octal; remember to add a leading zero")
+	ErrUnusualMode = errors.New("preserved mode of local file is unusual")
 
 	// mount units
 	ErrMountUnitNoPath   = errors.New("path is required if with_mount_unit is true and format is not swap")
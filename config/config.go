@@ -16,6 +16,9 @@ package config
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/coreos/butane/config/common"
 	fcos1_0 "github.com/coreos/butane/config/fcos/v1_0"
@@ -26,12 +29,14 @@ import (
 	fcos1_5_exp "github.com/coreos/butane/config/fcos/v1_5_exp"
 	flatcar1_0 "github.com/coreos/butane/config/flatcar/v1_0"
 	flatcar1_1_exp "github.com/coreos/butane/config/flatcar/v1_1_exp"
+	"github.com/coreos/butane/config/merge"
 	openshift4_10 "github.com/coreos/butane/config/openshift/v4_10"
 	openshift4_11 "github.com/coreos/butane/config/openshift/v4_11"
 	openshift4_12_exp "github.com/coreos/butane/config/openshift/v4_12_exp"
 	openshift4_8 "github.com/coreos/butane/config/openshift/v4_8"
 	openshift4_9 "github.com/coreos/butane/config/openshift/v4_9"
 	rhcos0_1 "github.com/coreos/butane/config/rhcos/v0_1"
+	cutil "github.com/coreos/butane/config/util"
 
 	"github.com/coreos/go-semver/semver"
 	"github.com/coreos/vcontext/report"
@@ -39,7 +44,10 @@ import (
 )
 
 var (
-	registry = map[string]translator{}
+	// registry is guarded by registryLock so translators can be
+	// registered while translations are running concurrently.
+	registry     = map[string]translator{}
+	registryLock sync.RWMutex
 )
 
 /// Fields that must be included in the root struct of every spec version.
@@ -68,7 +76,10 @@ func init() {
 /// RegisterTranslator registers a translator for the specified variant and
 /// version to be available for use by TranslateBytes.  This is only needed
 /// by users implementing their own translators outside the Butane package.
+/// It's safe to call concurrently with other functions in this package.
 func RegisterTranslator(variant, version string, trans translator) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
 	key := fmt.Sprintf("%s+%s", variant, version)
 	if _, ok := registry[key]; ok {
 		panic("tried to reregister existing translator")
@@ -77,6 +88,8 @@ func RegisterTranslator(variant, version string, trans translator) {
 }
 
 func getTranslator(variant string, version semver.Version) (translator, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
 	t, ok := registry[fmt.Sprintf("%s+%s", variant, version.String())]
 	if !ok {
 		return nil, fmt.Errorf("No translator exists for variant %s with version %s", variant, version.String())
@@ -84,6 +97,29 @@ func getTranslator(variant string, version semver.Version) (translator, error) {
 	return t, nil
 }
 
+// getVersions returns the registered versions of the specified variant,
+// sorted from oldest to newest.
+func getVersions(variant string) []semver.Version {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	var ret []semver.Version
+	for key := range registry {
+		parts := strings.SplitN(key, "+", 2)
+		if parts[0] != variant {
+			continue
+		}
+		ver, err := semver.NewVersion(parts[1])
+		if err != nil {
+			panic(fmt.Sprintf("invalid version in translator registry: %s", key))
+		}
+		ret = append(ret, *ver)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].LessThan(ret[j])
+	})
+	return ret
+}
+
 // translators take a raw config and translate it to a raw Ignition config. The report returned should include any
 // errors, warnings, etc. and may or may not be fatal. If report is fatal, or other errors are encountered while translating
 // translators should return an error.
@@ -91,27 +127,176 @@ type translator func([]byte, common.TranslateBytesOptions) ([]byte, report.Repor
 
 // TranslateBytes wraps all of the individual TranslateBytes functions in a switch that determines the correct one to call.
 // TranslateBytes returns an error if the report had fatal errors or if other errors occured during translation.
+// It's safe for concurrent use, provided options.Record isn't shared between calls.
+// If the config has a top-level merge section, the listed configs are first merged into it.
 func TranslateBytes(input []byte, options common.TranslateBytesOptions) ([]byte, report.Report, error) {
-	// first determine version; this will ignore most fields
+	if merge.HasIncludes(input) {
+		merged, err := merge.Merge([]merge.Fragment{{Data: input}}, options.TranslateOptions)
+		if err != nil {
+			return nil, report.Report{}, err
+		}
+		return TranslateMerged(merged, options)
+	}
+	return translateBytes(input, options)
+}
+
+// TranslateMerged translates a config produced by merging Butane configs.
+// Report entries point to the config and line that produced each field.
+func TranslateMerged(merged *merge.Result, options common.TranslateBytesOptions) ([]byte, report.Report, error) {
+	input, err := merged.Bytes()
+	if err != nil {
+		return nil, report.Report{}, err
+	}
+	out, r, err := translateBytes(input, options)
+	merged.MapReport(&r)
+	return out, r, err
+}
+
+func translateBytes(input []byte, options common.TranslateBytesOptions) ([]byte, report.Report, error) {
+	variant, version, err := parseVersion(input)
+	if err != nil {
+		return nil, report.Report{}, err
+	}
+
+	translator, err := getTranslator(variant, version)
+	if err != nil {
+		return nil, report.Report{}, err
+	}
+
+	out, r, err := translator(input, options)
+	addNewerVersionHints(input, variant, version, &r)
+	return out, r, err
+}
+
+// parseVersion returns the variant and version declared by the config.
+func parseVersion(input []byte) (string, semver.Version, error) {
+	// this will ignore most fields
 	ver := commonFields{}
 	if err := yaml.Unmarshal(input, &ver); err != nil {
-		return nil, report.Report{}, fmt.Errorf("Error unmarshaling yaml: %v", err)
+		return "", semver.Version{}, fmt.Errorf("Error unmarshaling yaml: %v", err)
 	}
 
 	if ver.Variant == "" {
-		return nil, report.Report{}, common.ErrNoVariant
+		return "", semver.Version{}, common.ErrNoVariant
 	}
 
 	tmp, err := semver.NewVersion(ver.Version)
 	if err != nil {
-		return nil, report.Report{}, common.ErrInvalidVersion
+		return "", semver.Version{}, common.ErrInvalidVersion
 	}
-	version := *tmp
+	return ver.Variant, *tmp, nil
+}
+
+// VersionReport describes why a config cannot be translated with a
+// particular spec version.
+type VersionReport struct {
+	Version semver.Version
+	// Report contains the entries that block use of the version: errors,
+	// and warnings about keys that the version doesn't support.
+	Report report.Report
+	// Err is the error returned by the translator, if any.
+	Err error
+}
 
-	translator, err := getTranslator(ver.Variant, version)
+// MinimumVersion translates the config with every registered spec version
+// of its variant and returns the lowest version that translates cleanly,
+// meaning without errors or unused keys.  It also returns a VersionReport
+// for each older version, explaining why that version can't be used.  If
+// no version translates cleanly, MinimumVersion returns nil and reports for
+// every version.
+func MinimumVersion(input []byte, options common.TranslateBytesOptions) (*semver.Version, []VersionReport, error) {
+	var merged *merge.Result
+	if merge.HasIncludes(input) {
+		var err error
+		merged, err = merge.Merge([]merge.Fragment{{Data: input}}, options.TranslateOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		input, err = merged.Bytes()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	variant, _, err := parseVersion(input)
 	if err != nil {
-		return nil, report.Report{}, err
+		return nil, nil, err
+	}
+	versions := getVersions(variant)
+	if len(versions) == 0 {
+		return nil, nil, fmt.Errorf("No translator exists for variant %s", variant)
+	}
+
+	var reports []VersionReport
+	for _, version := range versions {
+		translator, err := getTranslator(variant, version)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, r, err := translator(input, options)
+		var blockers report.Report
+		for _, entry := range r.Entries {
+			if entry.Kind.IsFatal() || cutil.IsUnusedKeyEntry(entry) {
+				blockers.Entries = append(blockers.Entries, entry)
+			}
+		}
+		if err == nil && len(blockers.Entries) == 0 {
+			ret := version
+			return &ret, reports, nil
+		}
+		if merged != nil {
+			merged.MapReport(&blockers)
+		}
+		reports = append(reports, VersionReport{
+			Version: version,
+			Report:  blockers,
+			Err:     err,
+		})
 	}
+	return nil, reports, nil
+}
 
-	return translator(input, options)
+// addNewerVersionHints annotates unused key warnings in r with the oldest
+// newer spec version of the variant in which the key is valid, if any.
+func addNewerVersionHints(input []byte, variant string, version semver.Version, r *report.Report) {
+	unused := make(map[string]int)
+	for i, entry := range r.Entries {
+		if cutil.IsUnusedKeyEntry(entry) {
+			unused[entry.Context.String()] = i
+		}
+	}
+	if len(unused) == 0 {
+		return
+	}
+	for _, newer := range getVersions(variant) {
+		if !version.LessThan(newer) {
+			continue
+		}
+		// Only the unused key check matters here.  Translate without
+		// a files dir or other options so we don't read local files.
+		translator, err := getTranslator(variant, newer)
+		if err != nil {
+			panic(err)
+		}
+		_, newerReport, err := translator(input, common.TranslateBytesOptions{})
+		if err != nil && len(newerReport.Entries) == 0 {
+			// couldn't parse the config; can't conclude anything
+			continue
+		}
+		stillUnused := make(map[string]struct{})
+		for _, entry := range newerReport.Entries {
+			if cutil.IsUnusedKeyEntry(entry) {
+				stillUnused[entry.Context.String()] = struct{}{}
+			}
+		}
+		for key, i := range unused {
+			if _, ok := stillUnused[key]; ok {
+				continue
+			}
+			r.Entries[i].Message += fmt.Sprintf("; key is only valid in %s spec version %s and later", variant, newer.String())
+			delete(unused, key)
+		}
+		if len(unused) == 0 {
+			return
+		}
+	}
 }
@@ -0,0 +1,136 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/report"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	documentSeparatorRe = regexp.MustCompile(`^---(\s|$)`)
+	outputHintRe        = regexp.MustCompile(`(?m)^output:.*$`)
+)
+
+// Document is the result of translating one document of a YAML stream.
+type Document struct {
+	// Number is the position of the document in the stream, starting
+	// from 1.
+	Number int
+	// Name is a suggested filename for the output: the document's
+	// top-level output field if specified, or else its metadata.name
+	// plus an extension for the output type.  It's empty if the document
+	// specifies neither.
+	Name   string
+	Output []byte
+	Report report.Report
+	Err    error
+	// Record receives details of the translation if the options
+	// requested a TranslationRecord.
+	Record *common.TranslationRecord
+}
+
+// documentFields are the fields read from each document before
+// translation.
+type documentFields struct {
+	commonFields `yaml:",inline"`
+	Output       string `yaml:"output"`
+	Metadata     struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+}
+
+// TranslateDocuments translates each document of a YAML stream separated
+// by "---" lines.  Each document can have its own variant and version.  A
+// top-level output field in a document is removed before translation and
+// used as the suggested output filename.  If the stream has more than one
+// document, report messages name the document.
+func TranslateDocuments(input []byte, options common.TranslateBytesOptions) []Document {
+	var ret []Document
+	sources := splitDocuments(input)
+	for i, source := range sources {
+		doc := Document{
+			Number: i + 1,
+		}
+		var fields documentFields
+		// ignore errors; translation will report them
+		_ = yaml.Unmarshal(source, &fields)
+		if fields.Output != "" {
+			// blank the line to preserve line numbering
+			source = outputHintRe.ReplaceAll(source, nil)
+		}
+
+		opts := options
+		if options.Record != nil {
+			doc.Record = &common.TranslationRecord{}
+			opts.Record = doc.Record
+		}
+		doc.Output, doc.Report, doc.Err = TranslateBytes(source, opts)
+		if len(sources) > 1 {
+			for j := range doc.Report.Entries {
+				doc.Report.Entries[j].Message += fmt.Sprintf(" (in document %d)", doc.Number)
+			}
+		}
+
+		switch {
+		case fields.Output != "":
+			doc.Name = fields.Output
+		case fields.Metadata.Name != "":
+			doc.Name = fields.Metadata.Name + ".ign"
+			if fields.Variant == "openshift" && !options.Raw {
+				doc.Name = fields.Metadata.Name + ".yaml"
+			}
+		}
+		ret = append(ret, doc)
+	}
+	return ret
+}
+
+// splitDocuments splits a YAML stream into its non-empty documents.  Each
+// document is preceded by blank lines so line numbers in reports match the
+// stream.
+func splitDocuments(input []byte) [][]byte {
+	var ret [][]byte
+	add := func(doc []byte) {
+		var node yaml.Node
+		if err := yaml.Unmarshal(doc, &node); err == nil && (node.Kind == 0 || len(node.Content) == 0) {
+			// only comments or whitespace
+			return
+		}
+		ret = append(ret, doc)
+	}
+
+	lines := bytes.SplitAfter(input, []byte("\n"))
+	var doc []byte
+	for i, line := range lines {
+		if documentSeparatorRe.Match(line) && i > 0 {
+			add(doc)
+			doc = bytes.Repeat([]byte("\n"), i)
+		}
+		doc = append(doc, line...)
+	}
+	add(doc)
+	if len(ret) == 0 {
+		// let translation report the problem
+		ret = append(ret, input)
+	}
+	return ret
+}
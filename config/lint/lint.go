@@ -0,0 +1,351 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package lint classifies the warnings produced by translation into rules
+// with stable IDs, runs additional registered rules on translated configs,
+// and applies user-configured severities to the results.
+package lint
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/translate"
+
+	ignerrors "github.com/coreos/ignition/v2/config/shared/errors"
+	"github.com/coreos/vcontext/report"
+	"github.com/coreos/vcontext/tree"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileName is the name of the lint configuration file.
+const ConfigFileName = ".butane-lint.yaml"
+
+type Severity string
+
+const (
+	SeverityOff     Severity = "off"
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+var (
+	ErrFailed = errors.New("config failed lint rules")
+
+	// registry is guarded by registryLock so rules can be registered
+	// while linting is in progress
+	registry     = map[string]Rule{}
+	registryLock sync.RWMutex
+)
+
+// Input is the result of a translation, as checked by rules.
+type Input struct {
+	// Config is the Butane config, after merging any configs it
+	// includes.
+	Config []byte
+	// Source is the context tree of Config, for finding line and
+	// column numbers.
+	Source tree.Node
+	// Output is the translated config.
+	Output []byte
+	// Translations maps paths in Output to paths in Config.
+	Translations translate.TranslationSet
+}
+
+// Rule is a lint rule.
+type Rule interface {
+	// ID returns the stable identifier of the rule, in lowercase
+	// words separated by hyphens.
+	ID() string
+	// DefaultSeverity returns the severity of the rule's findings
+	// unless the lint configuration overrides it.
+	DefaultSeverity() Severity
+	// Check returns the rule's findings for a successful translation,
+	// with paths in the Butane config.  The kinds of the entries are
+	// ignored.
+	Check(input Input) report.Report
+}
+
+// Config is a lint configuration, as read from ConfigFileName.
+type Config struct {
+	// Rules maps rule IDs to severities, overriding the defaults.
+	Rules map[string]Severity `yaml:"rules"`
+}
+
+// builtinRule identifies warnings produced during translation by their
+// code.
+type builtinRule struct {
+	id   string
+	code string
+}
+
+func (r builtinRule) ID() string {
+	return r.id
+}
+
+func (r builtinRule) DefaultSeverity() Severity {
+	return SeverityWarning
+}
+
+func (r builtinRule) Check(input Input) report.Report {
+	return report.Report{}
+}
+
+var builtinRules = []builtinRule{
+	{"unused-key", common.UnusedKeyCode},
+	{"decimal-mode", common.ErrorCode(common.ErrDecimalMode)},
+	{"unusual-mode", common.ErrorCode(common.ErrUnusualMode)},
+	{"wrong-partition-number", common.ErrorCode(common.ErrWrongPartitionNumber)},
+	{"field-elided", common.ErrorCode(common.ErrFieldElided)},
+	{"deprecated-variant", common.ErrorCode(common.ErrRhcosVariantDeprecated)},
+	{"secret-private-key", common.ErrorCode(common.ErrSecretPrivateKey)},
+	{"secret-credential", common.ErrorCode(common.ErrSecretCredential)},
+	{"secret-high-entropy", common.ErrorCode(common.ErrSecretHighEntropy)},
+	{"secret-world-readable", common.ErrorCode(common.ErrSecretWorldReadable)},
+	{"unused-suppression", common.ErrorCode(common.ErrUnusedSuppression)},
+	// warnings from Ignition validation
+	{"mode-special-bits", common.ErrorCode(ignerrors.ErrModeSpecialBits)},
+	{"insecure-proxy", common.ErrorCode(ignerrors.ErrInsecureProxy)},
+	{"no-install-section", common.NoInstallSectionCode},
+}
+
+func init() {
+	for _, rule := range builtinRules {
+		RegisterRule(rule)
+	}
+}
+
+// RegisterRule registers a lint rule.  It panics if a rule with the same
+// ID is already registered.
+func RegisterRule(rule Rule) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[rule.ID()]; ok {
+		panic("tried to reregister existing lint rule")
+	}
+	registry[rule.ID()] = rule
+}
+
+// Rules returns the registered rules, sorted by ID.
+func Rules() []Rule {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	ret := make([]Rule, 0, len(registry))
+	for _, rule := range registry {
+		ret = append(ret, rule)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID() < ret[j].ID()
+	})
+	return ret
+}
+
+func getRule(id string) (Rule, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	rule, ok := registry[id]
+	return rule, ok
+}
+
+// NewInput returns the Input for a translation, given its output and
+// TranslationRecord.
+func NewInput(output []byte, record *common.TranslationRecord) Input {
+	return Input{
+		Config:       record.Config,
+		Source:       record.Source,
+		Output:       output,
+		Translations: record.Translations,
+	}
+}
+
+// ParseConfig parses and validates a lint configuration.
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, err
+	}
+	for id, severity := range config.Rules {
+		if err := config.Set(id, severity); err != nil {
+			return Config{}, err
+		}
+	}
+	return config, nil
+}
+
+// ReadConfig reads and validates a lint configuration file.
+func ReadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	config, err := ParseConfig(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// FindConfig returns the path of the lint configuration file in dir or
+// its nearest parent, or the empty string if there isn't one.
+func FindConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, ConfigFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Set sets the severity of a rule, failing if the rule or severity is
+// unknown.
+func (c *Config) Set(id string, severity Severity) error {
+	if _, ok := getRule(id); !ok {
+		return fmt.Errorf("unknown lint rule %q", id)
+	}
+	switch severity {
+	case SeverityOff, SeverityInfo, SeverityWarning, SeverityError:
+	default:
+		return fmt.Errorf("invalid severity %q for lint rule %q; must be off, info, warning, or error", severity, id)
+	}
+	if c.Rules == nil {
+		c.Rules = make(map[string]Severity)
+	}
+	c.Rules[id] = severity
+	return nil
+}
+
+// Lint applies the configuration to a translation report.  Warnings and
+// informational entries produced by known rules get the severity
+// configured for the rule, or are removed if the rule is off; entries of
+// rules without a configured severity, errors, and unclassified entries
+// are kept unchanged.  If input has output, the findings of the
+// registered rules are added with their configured or default severity.
+//
+// Non-fatal entries and rule findings are also removed if they match a
+// "# butane:ignore <rule-or-message>" comment in input.Config, on the
+// line of the entry or of a key or list item containing it.  Comments
+// that don't match anything are reported.
+//
+// Lint returns the resulting report and, for each of its entries, the ID
+// of the rule that produced it, or the empty string if none did.  If the
+// report is fatal and the translation wasn't, the caller should fail with
+// ErrFailed.
+func Lint(r report.Report, input Input, config Config) (report.Report, []string) {
+	suppressions := findSuppressions(input)
+	var ret report.Report
+	var ids []string
+	add := func(e report.Entry, id string) {
+		ret.Entries = append(ret.Entries, e)
+		ids = append(ids, id)
+	}
+	for _, e := range r.Entries {
+		if e.Kind.IsFatal() {
+			add(e, "")
+			continue
+		}
+		id := classify(e)
+		if suppressed(suppressions, e, id) {
+			continue
+		}
+		if id == "" {
+			add(e, "")
+		} else if e, ok := config.apply(e, id, false); ok {
+			add(e, id)
+		}
+	}
+	if input.Output != nil {
+		for _, rule := range Rules() {
+			if _, ok := rule.(builtinRule); ok {
+				continue
+			}
+			findings := rule.Check(input)
+			if input.Source != nil {
+				findings.Correlate(input.Source)
+			}
+			for _, e := range findings.Entries {
+				if suppressed(suppressions, e, rule.ID()) {
+					continue
+				}
+				if e, ok := config.apply(e, rule.ID(), true); ok {
+					add(e, rule.ID())
+				}
+			}
+		}
+	}
+	for _, e := range unusedSuppressions(suppressions).Entries {
+		if e, ok := config.apply(e, "unused-suppression", false); ok {
+			add(e, "unused-suppression")
+		}
+	}
+	return ret, ids
+}
+
+// classify returns the ID of the built-in rule that produced the entry,
+// or the empty string if none did.
+func classify(e report.Entry) string {
+	code := common.EntryCode(e)
+	for _, rule := range builtinRules {
+		if rule.code == code {
+			return rule.id
+		}
+	}
+	return ""
+}
+
+// apply sets the kind of an entry from the severity configured for the
+// rule, or if there isn't one and useDefault is true, from the rule's
+// default severity.  It returns false if the rule is off.
+func (c Config) apply(e report.Entry, id string, useDefault bool) (report.Entry, bool) {
+	severity, ok := c.Rules[id]
+	if !ok {
+		if !useDefault {
+			return e, true
+		}
+		if rule, ok := getRule(id); ok {
+			severity = rule.DefaultSeverity()
+		}
+	}
+	switch severity {
+	case SeverityOff:
+		return e, false
+	case SeverityInfo:
+		common.SetEntryKind(&e, report.Info)
+	case SeverityError:
+		common.SetEntryKind(&e, report.Error)
+	default:
+		common.SetEntryKind(&e, report.Warn)
+	}
+	return e, true
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package lint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/stretchr/testify/assert"
+)
+
+// tmpRule reports files in /tmp, at the Butane config field that
+// produced them.
+type tmpRule struct{}
+
+func (tmpRule) ID() string {
+	return "test-tmp-file"
+}
+
+func (tmpRule) DefaultSeverity() Severity {
+	return SeverityInfo
+}
+
+func (tmpRule) Check(input Input) (r report.Report) {
+	var out struct {
+		Storage struct {
+			Files []struct {
+				Path string `json:"path"`
+			} `json:"files"`
+		} `json:"storage"`
+	}
+	if err := json.Unmarshal(input.Output, &out); err != nil {
+		r.AddOnError(path.New("yaml"), err)
+		return
+	}
+	for i, file := range out.Storage.Files {
+		if strings.HasPrefix(file.Path, "/tmp/") {
+			from := input.Translations.Set[path.New("json", "storage", "files", i, "path").String()].From
+			r.AddOnWarn(from, errors.New("file in /tmp will be lost on reboot"))
+		}
+	}
+	return
+}
+
+func init() {
+	RegisterRule(tmpRule{})
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		in  string
+		out Config
+		err error
+	}{
+		{
+			"",
+			Config{},
+			nil,
+		},
+		{
+			"rules:\n  unused-key: error\n  decimal-mode: off\n  test-tmp-file: warning\n",
+			Config{
+				Rules: map[string]Severity{
+					"unused-key":    SeverityError,
+					"decimal-mode":  SeverityOff,
+					"test-tmp-file": SeverityWarning,
+				},
+			},
+			nil,
+		},
+		{
+			"rules:\n  nonexistent: error\n",
+			Config{},
+			fmt.Errorf(`unknown lint rule "nonexistent"`),
+		},
+		{
+			"rules:\n  unused-key: fatal\n",
+			Config{},
+			fmt.Errorf(`invalid severity "fatal" for lint rule "unused-key"; must be off, info, warning, or error`),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("parse %d", i), func(t *testing.T) {
+			out, err := ParseConfig([]byte(test.in))
+			assert.Equal(t, test.err, err, "bad error")
+			assert.Equal(t, test.out, out, "bad config")
+		})
+	}
+
+	_, err := ParseConfig([]byte("rulez: {}\n"))
+	assert.Error(t, err, "unknown field accepted")
+}
+
+func TestFindConfig(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	found, err := FindConfig(sub)
+	assert.NoError(t, err)
+	assert.Equal(t, "", found, "found nonexistent config")
+
+	configPath := filepath.Join(dir, "a", ConfigFileName)
+	if err := os.WriteFile(configPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	found, err = FindConfig(sub)
+	assert.NoError(t, err)
+	assert.Equal(t, configPath, found, "bad config path")
+}
+
+func TestLint(t *testing.T) {
+	in := []byte(`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /tmp/x
+      mode: 644
+    - path: /etc/y
+      contnts: {}`)
+
+	tests := []struct {
+		rules  map[string]Severity
+		report string
+		ids    []string
+	}{
+		// defaults
+		{
+			nil,
+			"warning at $.storage.files.1.contnts, line 8 col 7: Unused key contnts; did you mean contents?\n" +
+				"warning at $.storage.files.0.mode, line 6 col 13: unreasonable mode would be reasonable if specified in octal; remember to add a leading zero\n" +
+				"warning at $.storage.files.0.mode, line 6 col 13: setuid/setgid/sticky bits are not supported in spec versions older than 3.4.0\n" +
+				"info at $.storage.files.0.path, line 5 col 13: file in /tmp will be lost on reboot\n",
+			[]string{"unused-key", "decimal-mode", "mode-special-bits", "test-tmp-file"},
+		},
+		// disabled, downgraded, and escalated rules
+		{
+			map[string]Severity{
+				"unused-key":        SeverityInfo,
+				"decimal-mode":      SeverityOff,
+				"mode-special-bits": SeverityOff,
+				"test-tmp-file":     SeverityError,
+			},
+			"info at $.storage.files.1.contnts, line 8 col 7: Unused key contnts; did you mean contents?\n" +
+				"error at $.storage.files.0.path, line 5 col 13: file in /tmp will be lost on reboot\n",
+			[]string{"unused-key", "test-tmp-file"},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("lint %d", i), func(t *testing.T) {
+			options := common.TranslateBytesOptions{}
+			options.Record = &common.TranslationRecord{}
+			out, r, err := config.TranslateBytes(in, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, ids := Lint(r, NewInput(out, options.Record), Config{Rules: test.rules})
+			assert.Equal(t, test.report, r.String(), "bad report")
+			assert.Equal(t, test.ids, ids, "bad rule IDs")
+		})
+	}
+
+	// errors and unclassified entries are unchanged, entries of rules
+	// without a configured severity keep their kind, and rules aren't
+	// run without output
+	r := report.Report{}
+	r.AddOnError(path.New("yaml", "storage"), errors.New("broken"))
+	r.AddOnWarn(path.New("yaml", "other"), errors.New("unclassified"))
+	r.AddOnInfo(path.New("yaml", "mode"), common.ErrDecimalMode)
+	r, ids := Lint(r, Input{}, Config{})
+	assert.Equal(t, "error at $.storage: broken\nwarning at $.other: unclassified\ninfo at $.mode: "+common.ErrDecimalMode.Error()+"\n", r.String(), "bad report")
+	assert.Equal(t, []string{"", "", "decimal-mode"}, ids, "bad rule IDs")
+}
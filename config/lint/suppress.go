@@ -0,0 +1,309 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/coreos/vcontext/tree"
+	"gopkg.in/yaml.v3"
+)
+
+var suppressionRe = regexp.MustCompile(`^#\s*butane:ignore\s+(.*)$`)
+
+// suppression is a "# butane:ignore" comment listing rule IDs or message
+// fragments to suppress.
+type suppression struct {
+	line   int64
+	column int64
+	ids    []string
+	used   []bool
+	// paths of the keys and list items that start on the line
+	paths []path.ContextPath
+}
+
+// findSuppressions returns the suppression comments in the Butane config,
+// in order.  A comment applies to entries on its line and entries under
+// any key or list item starting on its line.  Comments are read from the
+// YAML node tree, so text in block scalars that looks like a comment
+// isn't a suppression.
+func findSuppressions(input Input) []*suppression {
+	if input.Config == nil {
+		return nil
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(input.Config, &root); err != nil {
+		// translation reports the error
+		return nil
+	}
+	c := commentFinder{
+		lines:  strings.Split(string(input.Config), "\n"),
+		byLine: make(map[int64]*suppression),
+	}
+	c.walk(&root, c.end(&root))
+	sort.Slice(c.ret, func(i, j int) bool {
+		if c.ret[i].line != c.ret[j].line {
+			return c.ret[i].line < c.ret[j].line
+		}
+		return c.ret[i].column < c.ret[j].column
+	})
+	if len(c.ret) > 0 && input.Source != nil {
+		addSuppressionPaths(input.Source, path.New("yaml"), c.byLine)
+	}
+	return c.ret
+}
+
+// commentFinder collects the suppressions in the comments of a YAML node
+// tree.  The tree doesn't record the positions of comments, so they're
+// found in lines from the positions of the nodes they're attached to.
+type commentFinder struct {
+	lines  []string
+	ret    []*suppression
+	byLine map[int64]*suppression
+}
+
+// walk collects the suppressions in the comments of n and its
+// descendants.  end is the last line of n, including the value of n if
+// it's a mapping key.
+func (c *commentFinder) walk(n *yaml.Node, end int) {
+	for _, comment := range strings.Split(n.LineComment, "\n") {
+		c.add(n.Line, comment)
+	}
+	// head comments are directly above the node
+	head := strings.Split(n.HeadComment, "\n")
+	line := n.Line - 1
+	for i := len(head) - 1; i >= 0; i-- {
+		if strings.TrimSpace(head[i]) == "" {
+			continue
+		}
+		if line = c.find(line, -1, head[i]); line == 0 {
+			break
+		}
+		c.add(line, head[i])
+		line--
+	}
+	// foot comments follow the node
+	line = end + 1
+	for _, comment := range strings.Split(n.FootComment, "\n") {
+		if strings.TrimSpace(comment) == "" {
+			continue
+		}
+		if line = c.find(line, 1, comment); line == 0 {
+			break
+		}
+		c.add(line, comment)
+		line++
+	}
+
+	for i, child := range n.Content {
+		childEnd := c.end(child)
+		if n.Kind == yaml.MappingNode && i%2 == 0 && i+1 < len(n.Content) {
+			childEnd = c.end(n.Content[i+1])
+		}
+		c.walk(child, childEnd)
+	}
+}
+
+// find returns the number of the first line at or after start, in the
+// specified direction, that isn't blank, if that line is the comment.
+// Otherwise it returns 0.
+func (c *commentFinder) find(start, direction int, comment string) int {
+	comment = strings.TrimSpace(comment)
+	for line := start; line >= 1 && line <= len(c.lines); line += direction {
+		text := strings.TrimSpace(c.lines[line-1])
+		if text == "" {
+			continue
+		}
+		if text == comment {
+			return line
+		}
+		return 0
+	}
+	return 0
+}
+
+// add records the comment on the specified line if it's a suppression.
+func (c *commentFinder) add(line int, comment string) {
+	m := suppressionRe.FindStringSubmatch(strings.TrimSpace(comment))
+	if m == nil || line < 1 || line > len(c.lines) {
+		return
+	}
+	s := suppression{
+		line: int64(line),
+	}
+	if i := strings.LastIndex(c.lines[line-1], strings.TrimSpace(comment)); i >= 0 {
+		s.column = int64(i + 1)
+	}
+	for _, id := range strings.Split(m[1], ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			s.ids = append(s.ids, id)
+		}
+	}
+	s.used = make([]bool, len(s.ids))
+	c.ret = append(c.ret, &s)
+	c.byLine[s.line] = &s
+}
+
+// end returns the last line of a node and its descendants.
+func (c *commentFinder) end(n *yaml.Node) int {
+	end := n.Line
+	if n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 && n.Line >= 1 && n.Line <= len(c.lines) {
+		// block scalars continue on the following lines that are
+		// blank or indented more than the line with the indicator
+		indent := indentation(c.lines[n.Line-1])
+		for end < len(c.lines) {
+			next := c.lines[end]
+			if strings.TrimSpace(next) != "" && indentation(next) <= indent {
+				break
+			}
+			end++
+		}
+		// trailing blank lines aren't part of the scalar
+		for end > n.Line && strings.TrimSpace(c.lines[end-1]) == "" {
+			end--
+		}
+	}
+	for _, child := range n.Content {
+		if childEnd := c.end(child); childEnd > end {
+			end = childEnd
+		}
+	}
+	return end
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// addSuppressionPaths records the paths of keys and list items that start
+// on the lines of suppressions.
+func addSuppressionPaths(n tree.Node, p path.ContextPath, byLine map[int64]*suppression) {
+	switch n := n.(type) {
+	case tree.MapNode:
+		for key, leaf := range n.Keys {
+			if leaf.StartP == nil {
+				continue
+			}
+			if s, ok := byLine[leaf.StartP.Line]; ok {
+				s.paths = append(s.paths, p.Append(key))
+			}
+		}
+		for key, child := range n.Children {
+			addSuppressionPaths(child, p.Append(key), byLine)
+		}
+	case tree.SliceNode:
+		for i, child := range n.Children {
+			childPath := p.Append(i)
+			if line, _ := child.Start(); line > 0 {
+				if s, ok := byLine[line]; ok {
+					s.paths = append(s.paths, childPath)
+				}
+			}
+			addSuppressionPaths(child, childPath, byLine)
+		}
+	}
+}
+
+// suppressed returns true if a suppression matches the entry, which was
+// produced by the specified rule, if any.  A suppression matches an
+// entry by rule ID or by a fragment of its message.
+func suppressed(suppressions []*suppression, e report.Entry, id string) bool {
+	var line int64
+	if e.Marker.StartP != nil {
+		line = e.Marker.StartP.Line
+	}
+	ret := false
+	for _, s := range suppressions {
+		if s.line != line && !s.covers(e.Context) {
+			continue
+		}
+		for i, sid := range s.ids {
+			if sid == id || strings.Contains(e.Message, sid) {
+				s.used[i] = true
+				ret = true
+			}
+		}
+	}
+	return ret
+}
+
+// covers returns true if the path is under a key or list item starting
+// on the suppression's line.
+func (s *suppression) covers(p path.ContextPath) bool {
+	for _, prefix := range s.paths {
+		if len(p.Path) < len(prefix.Path) {
+			continue
+		}
+		match := true
+		for i, e := range prefix.Path {
+			// unused key warnings are reported at the key
+			elem := p.Path[i]
+			if key, ok := elem.(tree.Key); ok {
+				elem = string(key)
+			}
+			if elem != e {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// unusedSuppressions returns warnings for suppressed IDs that didn't match
+// any entry.
+func unusedSuppressions(suppressions []*suppression) report.Report {
+	var r report.Report
+	for _, s := range suppressions {
+		for i, id := range s.ids {
+			if s.used[i] {
+				continue
+			}
+			// report at the outermost key or list item on the
+			// line
+			var context path.ContextPath
+			for _, p := range s.paths {
+				if context.Len() == 0 || p.Len() < context.Len() || (p.Len() == context.Len() && p.String() < context.String()) {
+					context = p
+				}
+			}
+			r.Entries = append(r.Entries, report.Entry{
+				Kind: common.CodedKind{
+					Kind: report.Warn,
+					Code: common.ErrorCode(common.ErrUnusedSuppression),
+				},
+				Message: fmt.Sprintf("%s: %s", common.ErrUnusedSuppression, id),
+				Context: context,
+				Marker: tree.Marker{
+					StartP: &tree.Pos{
+						Line:   s.line,
+						Column: s.column,
+					},
+				},
+			})
+		}
+	}
+	return r
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package lint
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuppress(t *testing.T) {
+	tests := []struct {
+		in     string
+		rules  map[string]Severity
+		report string
+	}{
+		// no suppressions
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /a
+      mode: 644`,
+			nil,
+			"warning at $.storage.files.0.mode, line 6 col 13: unreasonable mode would be reasonable if specified in octal; remember to add a leading zero\n" +
+				"warning at $.storage.files.0.mode, line 6 col 13: setuid/setgid/sticky bits are not supported in spec versions older than 3.4.0\n",
+		},
+		// on the line, by rule ID and by message
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /a
+      mode: 644  # butane:ignore decimal-mode, sticky bits`,
+			nil,
+			"",
+		},
+		// on a list item and on a parent key
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /a  # butane:ignore decimal-mode
+      mode: 644
+    - path: /b
+      contnts: {}
+  directories:  # butane:ignore mode-special-bits
+    - path: /c
+      mode: 755`,
+			nil,
+			"warning at $.storage.files.1.contnts, line 8 col 7: Unused key contnts; did you mean contents?\n" +
+				"warning at $.storage.directories.0.mode, line 11 col 13: unreasonable mode would be reasonable if specified in octal; remember to add a leading zero\n" +
+				"warning at $.storage.files.0.mode, line 6 col 13: setuid/setgid/sticky bits are not supported in spec versions older than 3.4.0\n",
+		},
+		// unused keys, escalated rules, and registered rules
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /tmp/a  # butane:ignore test-tmp-file
+      contnts: {}  # butane:ignore unused-key`,
+			map[string]Severity{
+				"unused-key":    SeverityError,
+				"test-tmp-file": SeverityError,
+			},
+			"",
+		},
+		// unmatched suppressions
+		{
+			`variant: fcos
+version: 1.4.0
+# butane:ignore decimal-mode
+storage:
+  files:
+    - path: /a  # butane:ignore decimal-mode,unused-key
+      mode: 0644`,
+			nil,
+			"warning at line 3 col 1: butane:ignore comment didn't match any warning: decimal-mode\n" +
+				"warning at $.storage.files.0, line 6 col 17: butane:ignore comment didn't match any warning: decimal-mode\n" +
+				"warning at $.storage.files.0, line 6 col 17: butane:ignore comment didn't match any warning: unused-key\n",
+		},
+		// comment markers in block scalars aren't suppressions
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /a
+      mode: 644
+      contents:
+        inline: |
+          # butane:ignore decimal-mode
+          mode: 644  # butane:ignore mode-special-bits
+    - path: /b  # butane:ignore mode-special-bits
+      mode: 01644
+      contents:
+        inline: >-
+          # butane:ignore unused-key
+          text
+# butane:ignore unused-key`,
+			nil,
+			"warning at $.storage.files.0.mode, line 6 col 13: unreasonable mode would be reasonable if specified in octal; remember to add a leading zero\n" +
+				"warning at $.storage.files.0.mode, line 6 col 13: setuid/setgid/sticky bits are not supported in spec versions older than 3.4.0\n" +
+				"warning at line 17 col 1: butane:ignore comment didn't match any warning: unused-key\n",
+		},
+		// unmatched suppressions can be disabled
+		{
+			`variant: fcos
+version: 1.4.0
+storage:  # butane:ignore decimal-mode
+  files:
+    - path: /a`,
+			map[string]Severity{
+				"unused-suppression": SeverityOff,
+			},
+			"",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("suppress %d", i), func(t *testing.T) {
+			options := common.TranslateBytesOptions{}
+			options.Record = &common.TranslationRecord{}
+			out, r, err := config.TranslateBytes([]byte(test.in), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, _ = Lint(r, NewInput(out, options.Record), Config{Rules: test.rules})
+			assert.Equal(t, test.report, r.String(), "bad report")
+		})
+	}
+}
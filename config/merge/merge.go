@@ -0,0 +1,496 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package merge combines Butane configs into a single Butane config before
+// translation, following the same rules as Ignition config merging.
+package merge
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/coreos/vcontext/tree"
+	"gopkg.in/yaml.v3"
+)
+
+// includeKey is the top-level key listing configs to be merged into the
+// config before translation.
+const includeKey = "merge"
+
+var (
+	// Merge keys for lists of structs, by field name.  The key of a list
+	// item is the first of the specified fields that is set.  Items with
+	// the same key are merged; other items are appended.  Lists not
+	// listed here are appended without merging.
+	listKeys = map[string][]string{
+		"certificate_authorities": {"source"},
+		"directories":             {"path"},
+		"disks":                   {"device"},
+		"dropins":                 {"name"},
+		"files":                   {"path"},
+		"filesystems":             {"device"},
+		"groups":                  {"name"},
+		"http_headers":            {"name"},
+		"links":                   {"path"},
+		"luks":                    {"name"},
+		"merge":                   {"source"},
+		"partitions":              {"number", "label"},
+		"raid":                    {"name"},
+		"tang":                    {"url"},
+		"trees":                   {"local"},
+		"units":                   {"name"},
+		"users":                   {"name"},
+	}
+
+	// Lists whose items are appended even if they duplicate a parent
+	// item.
+	appendLists = map[string]struct{}{
+		"append":        {},
+		"mount_options": {},
+		"options":       {},
+	}
+
+	// Lists sharing a single key namespace.  An item in one of these
+	// lists replaces parent items with the same key in the others.
+	nodeLists = []string{"files", "directories", "links"}
+
+	// Resource fields specifying contents, of which only one can be set,
+	// and fields describing the contents.  A child that sets one of the
+	// contents fields replaces all of these fields in the parent.
+	contentsKeys    = []string{"source", "inline", "local", "json", "yaml", "toml", "ini"}
+	contentsDetails = []string{"compression", "http_headers", "verification"}
+)
+
+// Fragment is a Butane config to be merged.
+type Fragment struct {
+	// Name identifies the fragment in reports, usually by filename.
+	Name string
+	Data []byte
+}
+
+// Result is the merged config.  It remembers the fragment and line that
+// produced each field, so reports on the merged config can be mapped back
+// to the fragments.
+type Result struct {
+	doc     *yaml.Node
+	sources map[*yaml.Node]string
+}
+
+type loader struct {
+	options common.TranslateOptions
+	sources map[*yaml.Node]string
+	// files currently being loaded, for detecting include cycles
+	stack []string
+
+	// variant and version of the first fragment
+	first   string
+	variant string
+	version string
+}
+
+// HasIncludes returns true if the config lists other configs to be merged
+// into it.
+func HasIncludes(input []byte) bool {
+	var fields struct {
+		Merge yaml.Node `yaml:"merge"`
+	}
+	if err := yaml.Unmarshal(input, &fields); err != nil {
+		return false
+	}
+	return fields.Merge.Kind != 0
+}
+
+// Merge merges the fragments in order, with each fragment overriding the
+// ones before it.  Configs listed in a fragment's top-level merge section
+// are loaded from options.FS or options.FilesDir and merged before the fragment
+// itself, and are added to options.Record if specified.  All fragments must
+// have the same variant and version.
+func Merge(fragments []Fragment, options common.TranslateOptions) (*Result, error) {
+	if len(fragments) == 0 {
+		return nil, fmt.Errorf("no configs to merge")
+	}
+	l := loader{
+		options: options,
+		sources: make(map[*yaml.Node]string),
+	}
+	var root *yaml.Node
+	for _, fragment := range fragments {
+		node, err := l.load(fragment)
+		if err != nil {
+			return nil, err
+		}
+		root = mergeNodes(root, node, "")
+	}
+	return &Result{
+		doc: &yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{root},
+		},
+		sources: l.sources,
+	}, nil
+}
+
+// Bytes returns the merged Butane config.
+func (m *Result) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(m.doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MapReport updates the markers of report entries for the merged config
+// to point to the corresponding line in the fragment that produced the
+// field, and names the fragment in the message.
+func (m *Result) MapReport(r *report.Report) {
+	for i := range r.Entries {
+		node := m.lookup(r.Entries[i].Context)
+		r.Entries[i].Marker = tree.Marker{
+			StartP: &tree.Pos{
+				Line:   int64(node.Line),
+				Column: int64(node.Column),
+			},
+		}
+		if name := m.sources[node]; name != "" {
+			r.Entries[i].Message += fmt.Sprintf(" (in %s)", name)
+		}
+	}
+}
+
+// lookup returns the deepest node in the merged config matching p.
+func (m *Result) lookup(p path.ContextPath) *yaml.Node {
+	node := m.doc.Content[0]
+	for _, elem := range p.Path {
+		var next *yaml.Node
+		switch e := elem.(type) {
+		case int:
+			if node.Kind == yaml.SequenceNode && e >= 0 && e < len(node.Content) {
+				next = node.Content[e]
+			}
+		case string:
+			if i := findKey(node, e); i >= 0 {
+				next = node.Content[i+1]
+			}
+		case tree.Key:
+			if i := findKey(node, string(e)); i >= 0 {
+				next = node.Content[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return node
+}
+
+// load parses the fragment and merges in the configs it includes.
+func (l *loader) load(f Fragment) (*yaml.Node, error) {
+	wrap := func(err error) error {
+		if f.Name == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %w", f.Name, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(f.Data, &doc); err != nil {
+		return nil, wrap(fmt.Errorf("Error unmarshaling yaml: %v", err))
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, wrap(common.ErrMergeNotMapping)
+	}
+	root := doc.Content[0]
+	l.record(root, f.Name)
+	if err := l.checkVersion(root, f.Name); err != nil {
+		return nil, wrap(err)
+	}
+
+	i := findKey(root, includeKey)
+	if i < 0 {
+		return root, nil
+	}
+	includes := root.Content[i+1]
+	root.Content = append(root.Content[:i], root.Content[i+2:]...)
+	if includes.Kind != yaml.SequenceNode {
+		return nil, wrap(common.ErrMergeIncludeLocal)
+	}
+	var merged *yaml.Node
+	for _, include := range includes.Content {
+		node, err := l.loadInclude(include)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		merged = mergeNodes(merged, node, "")
+	}
+	return mergeNodes(merged, root, ""), nil
+}
+
+// loadInclude loads a config listed in a merge section.
+func (l *loader) loadInclude(include *yaml.Node) (*yaml.Node, error) {
+	var local string
+	if j := findKey(include, "local"); j >= 0 {
+		local = include.Content[j+1].Value
+	}
+	if local == "" {
+		return nil, common.ErrMergeIncludeLocal
+	}
+	fsys, err := baseutil.FilesFS(l.options)
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := baseutil.LocalPath(local)
+	if err != nil {
+		return nil, err
+	}
+	l.options.Record.AddFile(baseutil.RecordPath(fsys, filePath))
+	for _, loading := range l.stack {
+		if loading == filePath {
+			return nil, fmt.Errorf("%s: %w", local, common.ErrMergeCycle)
+		}
+	}
+	data, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return nil, err
+	}
+	l.stack = append(l.stack, filePath)
+	defer func() {
+		l.stack = l.stack[:len(l.stack)-1]
+	}()
+	return l.load(Fragment{
+		Name: local,
+		Data: data,
+	})
+}
+
+// checkVersion ensures that all fragments have the same variant and
+// version.
+func (l *loader) checkVersion(root *yaml.Node, name string) error {
+	var variant, version string
+	if i := findKey(root, "variant"); i >= 0 {
+		variant = root.Content[i+1].Value
+	}
+	if i := findKey(root, "version"); i >= 0 {
+		version = root.Content[i+1].Value
+	}
+	if l.variant == "" && l.version == "" {
+		l.first = name
+		l.variant = variant
+		l.version = version
+		return nil
+	}
+	if variant != l.variant || version != l.version {
+		first := l.first
+		if first == "" {
+			first = "config"
+		}
+		return fmt.Errorf("%w: %s %s doesn't match %s %s in %s", common.ErrMergeVersionMismatch, variant, version, l.variant, l.version, first)
+	}
+	return nil
+}
+
+// record remembers the fragment name of every node in the tree.
+func (l *loader) record(node *yaml.Node, name string) {
+	l.sources[node] = name
+	for _, child := range node.Content {
+		l.record(child, name)
+	}
+}
+
+// mergeNodes merges child into parent and returns the result.  field is
+// the name of the field containing the nodes, if any.
+func mergeNodes(parent, child *yaml.Node, field string) *yaml.Node {
+	switch {
+	case parent == nil:
+		return child
+	case parent.Kind == yaml.MappingNode && child.Kind == yaml.MappingNode:
+		mergeMappings(parent, child)
+		return parent
+	case parent.Kind == yaml.SequenceNode && child.Kind == yaml.SequenceNode:
+		mergeSequences(parent, child, field)
+		return parent
+	case child.Kind == yaml.ScalarNode && child.Tag == "!!null":
+		// like nil pointers in Ignition configs
+		return parent
+	default:
+		return child
+	}
+}
+
+func mergeMappings(parent, child *yaml.Node) {
+	removeReplacedNodes(parent, child)
+	removeReplacedContents(parent, child)
+	for i := 0; i+1 < len(child.Content); i += 2 {
+		key := child.Content[i]
+		value := child.Content[i+1]
+		j := findKey(parent, key.Value)
+		if j < 0 {
+			parent.Content = append(parent.Content, key, value)
+			continue
+		}
+		merged := mergeNodes(parent.Content[j+1], value, key.Value)
+		if merged != parent.Content[j+1] {
+			// child replaced the value; the key now comes from
+			// the child too
+			parent.Content[j] = key
+			parent.Content[j+1] = merged
+		}
+	}
+}
+
+func mergeSequences(parent, child *yaml.Node, field string) {
+	if _, ok := appendLists[field]; ok {
+		parent.Content = append(parent.Content, child.Content...)
+		return
+	}
+	keyFields := listKeys[field]
+	// don't merge child items with each other
+	parentLen := len(parent.Content)
+	for _, item := range child.Content {
+		if item.Kind == yaml.ScalarNode {
+			// lists of primitives are keyed by value
+			if findScalar(parent.Content[:parentLen], item.Value) < 0 {
+				parent.Content = append(parent.Content, item)
+			}
+			continue
+		}
+		if key := itemKey(item, keyFields); key != "" {
+			if j := findItem(parent.Content[:parentLen], key, keyFields); j >= 0 {
+				parent.Content[j] = mergeNodes(parent.Content[j], item, "")
+				continue
+			}
+		}
+		parent.Content = append(parent.Content, item)
+	}
+}
+
+// removeReplacedNodes removes items from parent node lists that have the
+// same key as an item in a different child node list.
+func removeReplacedNodes(parent, child *yaml.Node) {
+	for _, list := range nodeLists {
+		i := findKey(child, list)
+		if i < 0 || child.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		keyFields := listKeys[list]
+		for _, other := range nodeLists {
+			j := findKey(parent, other)
+			if other == list || j < 0 || parent.Content[j+1].Kind != yaml.SequenceNode {
+				continue
+			}
+			otherList := parent.Content[j+1]
+			var kept []*yaml.Node
+			for _, item := range otherList.Content {
+				if findItem(child.Content[i+1].Content, itemKey(item, keyFields), keyFields) < 0 {
+					kept = append(kept, item)
+				}
+			}
+			otherList.Content = kept
+		}
+	}
+}
+
+// removeReplacedContents removes the contents fields of parent, and the
+// fields describing them, if child sets contents, so the merged resource
+// has only the child's contents.
+func removeReplacedContents(parent, child *yaml.Node) {
+	replaced := false
+	for _, key := range contentsKeys {
+		if i := findKey(child, key); i >= 0 && child.Content[i+1].Tag != "!!null" {
+			replaced = true
+		}
+	}
+	if !replaced {
+		return
+	}
+	var kept []*yaml.Node
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		key := parent.Content[i].Value
+		if contains(contentsKeys, key) || contains(contentsDetails, key) {
+			continue
+		}
+		kept = append(kept, parent.Content[i], parent.Content[i+1])
+	}
+	parent.Content = kept
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// itemKey returns the merge key of a list item, or "" if it has none.
+func itemKey(item *yaml.Node, keyFields []string) string {
+	for _, field := range keyFields {
+		i := findKey(item, field)
+		if i < 0 {
+			continue
+		}
+		value := item.Content[i+1]
+		if value.Kind != yaml.ScalarNode || value.Tag == "!!null" || (field == "number" && value.Value == "0") {
+			continue
+		}
+		return field + ":" + value.Value
+	}
+	return ""
+}
+
+func findItem(items []*yaml.Node, key string, keyFields []string) int {
+	if key == "" {
+		return -1
+	}
+	for i, item := range items {
+		if itemKey(item, keyFields) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func findScalar(items []*yaml.Node, value string) int {
+	for i, item := range items {
+		if item.Kind == yaml.ScalarNode && item.Value == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// findKey returns the index of the specified key in a mapping node, or -1.
+func findKey(node *yaml.Node, key string) int {
+	if node.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,232 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/coreos/vcontext/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	filesDir := t.TempDir()
+	includes := map[string]string{
+		"base.bu":   "variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /a\n",
+		"nested.bu": "variant: fcos\nversion: 1.4.0\nmerge:\n  - local: base.bu\nsystemd:\n  units:\n    - name: a.service\n",
+		"loop.bu":   "variant: fcos\nversion: 1.4.0\nmerge:\n  - local: loop.bu\n",
+		"old.bu":    "variant: fcos\nversion: 1.3.0\n",
+	}
+	for name, contents := range includes {
+		if err := ioutil.WriteFile(filepath.Join(filesDir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		in  []string
+		out string
+		err error
+	}{
+		// scalars, maps, keyed lists, and lists of primitives
+		{
+			[]string{
+				`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /a
+      mode: 0644
+      contents:
+        inline: a
+    - path: /b
+  filesystems:
+    - device: /dev/vda
+      mount_options: [ro]
+passwd:
+  users:
+    - name: core
+      ssh_authorized_keys: [key1]
+      password_hash: foo
+`,
+				`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /a
+      contents:
+        inline: aa
+    - path: /c
+  filesystems:
+    - device: /dev/vda
+      mount_options: [ro]
+passwd:
+  users:
+    - name: core
+      ssh_authorized_keys: [key1, key2]
+      password_hash: null
+`,
+			},
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /a
+      mode: 0644
+      contents:
+        inline: aa
+    - path: /b
+    - path: /c
+  filesystems:
+    - device: /dev/vda
+      mount_options: [ro, ro]
+passwd:
+  users:
+    - name: core
+      ssh_authorized_keys: [key1, key2]
+      password_hash: foo
+`,
+			nil,
+		},
+		// node types sharing a namespace
+		{
+			[]string{
+				"variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /a\n    - path: /b\n",
+				"variant: fcos\nversion: 1.4.0\nstorage:\n  links:\n    - path: /a\n      target: /b\n",
+			},
+			"variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /b\n  links:\n    - path: /a\n      target: /b\n",
+			nil,
+		},
+		// contents fields replace each other
+		{
+			[]string{
+				"variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /a\n      contents:\n        source: https://example.com/a\n        compression: gzip\n        verification:\n          hash: sha512-00\n    - path: /b\n      contents:\n        inline: b\n",
+				"variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /a\n      contents:\n        inline: a\n    - path: /b\n      contents:\n        local: b\n        source: null\n",
+			},
+			"variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /a\n      contents:\n        inline: a\n    - path: /b\n      contents:\n        local: b\n        source: null\n",
+			nil,
+		},
+		// nested includes, merged before the including config
+		{
+			[]string{
+				"variant: fcos\nversion: 1.4.0\nmerge:\n  - local: nested.bu\nstorage:\n  files:\n    - path: /a\n      mode: 0600\n",
+			},
+			"variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /a\n      mode: 0600\nsystemd:\n  units:\n    - name: a.service\n",
+			nil,
+		},
+		// version mismatch
+		{
+			[]string{
+				"variant: fcos\nversion: 1.4.0\n",
+				"variant: fcos\nversion: 1.3.0\n",
+			},
+			"",
+			common.ErrMergeVersionMismatch,
+		},
+		{
+			[]string{
+				"variant: fcos\nversion: 1.4.0\nmerge:\n  - local: old.bu\n",
+			},
+			"",
+			common.ErrMergeVersionMismatch,
+		},
+		// include cycle
+		{
+			[]string{
+				"variant: fcos\nversion: 1.4.0\nmerge:\n  - local: loop.bu\n",
+			},
+			"",
+			common.ErrMergeCycle,
+		},
+		// bad include
+		{
+			[]string{
+				"variant: fcos\nversion: 1.4.0\nmerge:\n  - source: https://example.com/\n",
+			},
+			"",
+			common.ErrMergeIncludeLocal,
+		},
+		{
+			[]string{
+				"variant: fcos\nversion: 1.4.0\nmerge:\n  - local: ../escape.bu\n",
+			},
+			"",
+			common.ErrFilesDirEscape,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("merge %d", i), func(t *testing.T) {
+			var fragments []Fragment
+			for j, in := range test.in {
+				fragments = append(fragments, Fragment{
+					Name: fmt.Sprintf("%d.bu", j),
+					Data: []byte(in),
+				})
+			}
+			merged, err := Merge(fragments, common.TranslateOptions{
+				FilesDir: filesDir,
+			})
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err), "bad error: %v", err)
+				return
+			}
+			if !assert.NoError(t, err, "merge failed") {
+				return
+			}
+			out, err := merged.Bytes()
+			assert.NoError(t, err, "serialization failed")
+			assert.Equal(t, test.out, string(out), "bad output")
+		})
+	}
+}
+
+func TestMapReport(t *testing.T) {
+	merged, err := Merge([]Fragment{
+		{
+			Name: "base.bu",
+			Data: []byte("variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /a\n"),
+		},
+		{
+			Name: "overlay.bu",
+			Data: []byte("variant: fcos\nversion: 1.4.0\n\nstorage:\n  files:\n    - path: /a\n      mode: 0644\n    - path: /b\n"),
+		},
+	}, common.TranslateOptions{})
+	if !assert.NoError(t, err, "merge failed") {
+		return
+	}
+	var r report.Report
+	r.AddOnWarn(path.New("yaml", "storage", "files", 0, "mode"), errors.New("a"))
+	r.AddOnWarn(path.New("yaml", "storage", "files", 1, tree.Key("path")), errors.New("b"))
+	r.AddOnWarn(path.New("yaml", "storage", "files", 0), errors.New("c"))
+	merged.MapReport(&r)
+	var actual []string
+	for _, entry := range r.Entries {
+		actual = append(actual, entry.String())
+	}
+	assert.Equal(t, []string{
+		"warning at $.storage.files.0.mode, line 7 col 13: a (in overlay.bu)",
+		"warning at $.storage.files.1.path, line 8 col 7: b (in overlay.bu)",
+		"warning at $.storage.files.0, line 5 col 7: c (in base.bu)",
+	}, actual, "bad report")
+}
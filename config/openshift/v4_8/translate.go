@@ -45,6 +45,9 @@ func (c Config) ToMachineConfig4_8Unvalidated(options common.TranslateOptions) (
 	// disable inline resource compression since the MCO doesn't support it
 	// https://bugzilla.redhat.com/show_bug.cgi?id=1970218
 	options.NoResourceAutoCompression = true
+	if options.Record != nil {
+		options.Record.NoResourceAutoCompression = true
+	}
 
 	cfg, ts, r := c.Config.ToIgn3_2Unvalidated(options)
 	if r.IsFatal() {
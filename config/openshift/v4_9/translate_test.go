@@ -47,9 +47,9 @@ func TestElidedFieldWarning(t *testing.T) {
 	}
 
 	var expected report.Report
-	expected.AddOnWarn(path.New("yaml", "openshift", "kernel_arguments"), common.ErrFieldElided)
-	expected.AddOnWarn(path.New("yaml", "openshift", "fips"), common.ErrFieldElided)
-	expected.AddOnWarn(path.New("yaml", "openshift", "kernel_type"), common.ErrFieldElided)
+	common.AddOn(&expected, path.New("yaml", "openshift", "kernel_arguments"), common.ErrFieldElided, report.Warn)
+	common.AddOn(&expected, path.New("yaml", "openshift", "fips"), common.ErrFieldElided, report.Warn)
+	common.AddOn(&expected, path.New("yaml", "openshift", "kernel_type"), common.ErrFieldElided, report.Warn)
 
 	_, _, r := in.ToIgn3_2Unvalidated(common.TranslateOptions{})
 	assert.Equal(t, expected, r, "report mismatch")
@@ -360,7 +360,7 @@ func TestTranslateConfig(t *testing.T) {
 // Test post-translation validation of RHCOS/MCO support for Ignition config fields.
 func TestValidateSupport(t *testing.T) {
 	type entry struct {
-		kind report.EntryKind
+		kind report.Kind
 		err  error
 		path path.ContextPath
 	}
@@ -545,7 +545,7 @@ func TestValidateSupport(t *testing.T) {
 		t.Run(fmt.Sprintf("translate %d", i), func(t *testing.T) {
 			var expectedReport report.Report
 			for _, entry := range test.entries {
-				expectedReport.AddOn(entry.path, entry.err, entry.kind)
+				common.AddOn(&expectedReport, entry.path, entry.err, entry.kind)
 			}
 			actual, translations, r := test.in.ToMachineConfig4_9Unvalidated(common.TranslateOptions{})
 			assert.Equal(t, expectedReport, r, "report mismatch")
@@ -0,0 +1,418 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/translate"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+)
+
+// remoteFetcher downloads remote resources with the proxy and certificate
+// authorities of a config, optionally through an on-disk cache.
+type remoteFetcher struct {
+	client   *http.Client
+	cacheDir string
+}
+
+// fetchRemoteResources downloads the http and https resources in v, an
+// addressable translated config, and embeds them as data URLs or pins
+// their sha512 hashes as requested by options.  Translations for new
+// fields are added to ts.  The returned report uses JSON paths.
+func fetchRemoteResources(v reflect.Value, ts translate.TranslationSet, options common.TranslateOptions) (r report.Report) {
+	var proxy reflect.Value
+	var cas, resources []path.ContextPath
+	values := make(map[string]reflect.Value)
+	walkOutputStructs(v, path.New("json"), func(p path.ContextPath, v reflect.Value) bool {
+		switch v.Type().Name() {
+		case "Proxy":
+			proxy = v
+			return true
+		case "Resource":
+			p = p.Copy()
+			values[p.String()] = v
+			if len(p.Path) > 1 && p.Path[len(p.Path)-2] == "certificateAuthorities" {
+				cas = append(cas, p)
+			} else {
+				resources = append(resources, p)
+			}
+			return true
+		}
+		return false
+	})
+	if len(cas)+len(resources) == 0 {
+		return
+	}
+
+	timeout := options.RemoteTimeout
+	if timeout == 0 {
+		timeout = common.DefaultRemoteTimeout
+	}
+	f := remoteFetcher{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: proxyFunc(proxy),
+			},
+			// a stalled server shouldn't hang translation
+			Timeout: timeout,
+		},
+		cacheDir: options.RemoteCacheDir,
+	}
+	// CAs are fetched with the system roots, and the rest with the CAs
+	// added
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, p := range cas {
+		contents, rr := f.process(p, values[p.String()], ts, options)
+		r.Merge(rr)
+		if rr.IsFatal() {
+			continue
+		}
+		if contents == nil {
+			contents, err = resourceContents(values[p.String()])
+			if err != nil {
+				common.AddOn(&r, p.Append("source"), err, report.Error)
+				continue
+			}
+		}
+		if contents != nil && !pool.AppendCertsFromPEM(contents) {
+			common.AddOn(&r, p.Append("source"), common.ErrRemoteCertificate, report.Error)
+		}
+	}
+	if r.IsFatal() {
+		return
+	}
+	f.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+		RootCAs: pool,
+	}
+	for _, p := range resources {
+		_, rr := f.process(p, values[p.String()], ts, options)
+		r.Merge(rr)
+	}
+	return
+}
+
+// process fetches the resource at p, if it's remote, and embeds or pins
+// it.  It returns the decompressed contents of a remote resource, or nil
+// if the resource isn't remote.
+func (f remoteFetcher) process(p path.ContextPath, v reflect.Value, ts translate.TranslationSet, options common.TranslateOptions) (contents []byte, r report.Report) {
+	source := stringField(v, "Source")
+	if source == nil {
+		return
+	}
+	u, err := url.Parse(*source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+	sourcePath := p.Append("source")
+	compression := stringField(v, "Compression")
+	hashField := v.FieldByName("Verification").FieldByName("Hash")
+
+	data, err := f.fetch(*source, headers(v))
+	if err != nil {
+		common.AddOn(&r, sourcePath, err, report.Error)
+		return
+	}
+	contents = data
+	if compression != nil && *compression == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			common.AddOn(&r, sourcePath, err, report.Error)
+			return
+		}
+		if contents, err = io.ReadAll(zr); err != nil {
+			common.AddOn(&r, sourcePath, err, report.Error)
+			return
+		}
+	}
+	if !hashField.IsNil() {
+		if err := verifyHash(*hashField.Interface().(*string), contents); err != nil {
+			common.AddOn(&r, p.Append("verification", "hash"), err, report.Error)
+			return
+		}
+	}
+
+	from := sourcePath
+	if t, ok := ts.Set[sourcePath.String()]; ok {
+		from = t.From
+	}
+	if options.PinRemote && hashField.IsNil() {
+		sum := sha512.Sum512(contents)
+		pinned := "sha512-" + hex.EncodeToString(sum[:])
+		hashField.Set(reflect.ValueOf(&pinned))
+		ts.AddTranslation(from, p.Append("verification", "hash"))
+		if _, ok := ts.Set[p.Append("verification").String()]; !ok {
+			ts.AddTranslation(from, p.Append("verification"))
+		}
+	}
+	if options.EmbedRemote {
+		uri, newCompression, err := baseutil.MakeDataURL(data, compression, !options.NoResourceAutoCompression)
+		if err != nil {
+			common.AddOn(&r, sourcePath, err, report.Error)
+			return
+		}
+		options.Record.AddEmbeddedSource(sourcePath, *source)
+		v.FieldByName("Source").Set(reflect.ValueOf(&uri))
+		if newCompression != nil {
+			v.FieldByName("Compression").Set(reflect.ValueOf(newCompression))
+			ts.AddTranslation(from, p.Append("compression"))
+		}
+		// headers are only valid for HTTP sources
+		if headers := v.FieldByName("HTTPHeaders"); headers.IsValid() {
+			headers.Set(reflect.Zero(headers.Type()))
+		}
+	}
+	return
+}
+
+// fetch returns the contents of the URL, reading and updating the cache if
+// one is configured.
+func (f remoteFetcher) fetch(source string, headers http.Header) ([]byte, error) {
+	var cachePath string
+	if f.cacheDir != "" {
+		key := sha256.New()
+		fmt.Fprintln(key, source)
+		if err := headers.Write(key); err != nil {
+			return nil, err
+		}
+		cachePath = filepath.Join(f.cacheDir, hex.EncodeToString(key.Sum(nil)))
+		if data, err := os.ReadFile(cachePath); err == nil {
+			return data, nil
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headers
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if cachePath != "" {
+		if err := os.MkdirAll(f.cacheDir, 0755); err != nil {
+			return nil, err
+		}
+		// write atomically, since concurrent translations may share
+		// the cache
+		tmp, err := os.CreateTemp(f.cacheDir, ".tmp-")
+		if err != nil {
+			return nil, err
+		}
+		_, err = tmp.Write(data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), cachePath)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// walkOutputStructs calls fn on each struct in v with its JSON path,
+// descending into the struct unless fn returns true.
+func walkOutputStructs(v reflect.Value, p path.ContextPath, fn func(path.ContextPath, reflect.Value) bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			walkOutputStructs(v.Elem(), p, fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkOutputStructs(v.Index(i), p.Append(i), fn)
+		}
+	case reflect.Struct:
+		if fn(p, v) {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if field.Anonymous {
+				walkOutputStructs(v.Field(i), p, fn)
+				continue
+			}
+			tag := strings.Split(field.Tag.Get("json"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			walkOutputStructs(v.Field(i), p.Append(tag), fn)
+		}
+	}
+}
+
+func stringField(v reflect.Value, name string) *string {
+	f := v.FieldByName(name)
+	if !f.IsValid() || f.IsNil() {
+		return nil
+	}
+	return f.Interface().(*string)
+}
+
+// headers returns the HTTP headers of a resource.
+func headers(v reflect.Value) http.Header {
+	ret := make(http.Header)
+	list := v.FieldByName("HTTPHeaders")
+	if !list.IsValid() {
+		return ret
+	}
+	for i := 0; i < list.Len(); i++ {
+		if value := stringField(list.Index(i), "Value"); value != nil {
+			ret.Add(list.Index(i).FieldByName("Name").String(), *value)
+		}
+	}
+	return ret
+}
+
+// resourceContents returns the decoded contents of an embedded resource,
+// or nil if it isn't embedded.
+func resourceContents(v reflect.Value) ([]byte, error) {
+	source := stringField(v, "Source")
+	if source == nil || !strings.HasPrefix(*source, "data:") {
+		return nil, nil
+	}
+	return baseutil.DecodeDataURL(*source, stringField(v, "Compression"))
+}
+
+func verifyHash(spec string, contents []byte) error {
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		// reported by validation
+		return nil
+	}
+	var h hash.Hash
+	switch parts[0] {
+	case "sha512":
+		h = sha512.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return nil
+	}
+	h.Write(contents)
+	if hex.EncodeToString(h.Sum(nil)) != strings.ToLower(parts[1]) {
+		return common.ErrRemoteHashMismatch
+	}
+	return nil
+}
+
+// proxyFunc returns a function selecting the proxy for a request according
+// to the fields of an Ignition Proxy struct, if valid.
+func proxyFunc(proxy reflect.Value) func(*http.Request) (*url.URL, error) {
+	if !proxy.IsValid() {
+		return nil
+	}
+	httpProxy := stringField(proxy, "HTTPProxy")
+	httpsProxy := stringField(proxy, "HTTPSProxy")
+	var noProxy []string
+	if list := proxy.FieldByName("NoProxy"); list.IsValid() {
+		for i := 0; i < list.Len(); i++ {
+			noProxy = append(noProxy, list.Index(i).String())
+		}
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		proxy := httpProxy
+		if req.URL.Scheme == "https" {
+			proxy = httpsProxy
+		}
+		if proxy == nil || *proxy == "" || bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		return url.Parse(*proxy)
+	}
+}
+
+// bypassProxy returns true if u matches an entry of noProxy: "*", an IP
+// address or CIDR prefix, or a domain name matching itself and its
+// subdomains, or only its subdomains with a leading ".".  Entries may
+// include a port.
+func bypassProxy(u *url.URL, noProxy []string) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	ip := net.ParseIP(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return true
+			}
+		} else if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBypassProxy(t *testing.T) {
+	tests := []struct {
+		url     string
+		noProxy []string
+		out     bool
+	}{
+		{"https://example.com/", nil, false},
+		{"https://example.com/", []string{"*"}, true},
+		{"https://example.com/", []string{"example.com"}, true},
+		{"https://a.example.com/", []string{"example.com"}, true},
+		{"https://example.com/", []string{".example.com"}, false},
+		{"https://a.example.com/", []string{".example.com"}, true},
+		{"https://badexample.com/", []string{"example.com"}, false},
+		{"https://EXAMPLE.com/", []string{"example.com"}, true},
+		{"https://example.com:8443/", []string{"example.com:8443"}, true},
+		{"https://example.com/", []string{"example.com:8443"}, false},
+		{"http://10.1.2.3/", []string{"10.0.0.0/8"}, true},
+		{"http://11.1.2.3/", []string{"10.0.0.0/8"}, false},
+		{"http://1.2.3.4:80/", []string{"1.2.3.4"}, true},
+		{"http://[::1]/", []string{"::1"}, true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("bypass %d", i), func(t *testing.T) {
+			u, err := url.Parse(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, test.out, bypassProxy(u, test.noProxy), "bad result")
+		})
+	}
+}
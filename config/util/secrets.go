@@ -0,0 +1,217 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	slashpath "path"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+)
+
+var (
+	privateKeyRe = regexp.MustCompile(`-----BEGIN ((?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?)-----`)
+	pemBeginRe   = regexp.MustCompile(`^-----BEGIN [A-Z0-9 ]+-----`)
+	pemEndRe     = regexp.MustCompile(`^-----END [A-Z0-9 ]+-----`)
+	tokenRe      = regexp.MustCompile(`[A-Za-z0-9+/=_-]{32,}`)
+
+	credentialRes = []struct {
+		description string
+		re          *regexp.Regexp
+	}{
+		{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{"AWS secret access key", regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}`)},
+		{"GitHub token", regexp.MustCompile(`\bgh[oprsu]_[A-Za-z0-9]{36,}\b`)},
+		{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+		{"Slack token", regexp.MustCompile(`\bxox[abeoprs]-[A-Za-z0-9-]{10,}`)},
+		{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+		{"Stripe secret key", regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}`)},
+		{"kubeconfig client key", regexp.MustCompile(`client-key-data:\s*[A-Za-z0-9+/=]{16,}`)},
+		{"JSON Web Token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	}
+)
+
+// minimum Shannon entropy, in bits per character, of a token that may be
+// a secret
+const secretEntropyThreshold = 4.3
+
+// secretFinding describes a possible secret in some contents.
+type secretFinding struct {
+	err         error
+	description string
+	line        int
+}
+
+// scanSecrets scans the embedded contents of the files and systemd units
+// in v, a translated config, for secrets.  Files matching a pattern in
+// options.SecretAllowlist are skipped, but reported if they're
+// world-readable.  The returned report uses JSON paths.
+func scanSecrets(v reflect.Value, options common.TranslateOptions) (r report.Report) {
+	walkOutputStructs(v, path.New("json"), func(p path.ContextPath, v reflect.Value) bool {
+		switch v.Type().Name() {
+		case "File":
+			name := v.FieldByName("Path").String()
+			if secretAllowed(name, options.SecretAllowlist) {
+				mode := v.FieldByName("Mode")
+				if mode.IsNil() {
+					// Ignition defaults to 0644
+					common.AddOn(&r, p.Append("path"), fmt.Errorf("%s: %w (default mode 0644)", name, common.ErrSecretWorldReadable), report.Warn)
+				} else if mode.Elem().Int()&0004 != 0 {
+					common.AddOn(&r, p.Append("mode"), fmt.Errorf("%s: %w (%#o)", name, common.ErrSecretWorldReadable, mode.Elem().Int()), report.Warn)
+				}
+				return true
+			}
+			scanResource(&r, p.Append("contents"), name, v.FieldByName("Contents"))
+			appends := v.FieldByName("Append")
+			for i := 0; i < appends.Len(); i++ {
+				scanResource(&r, p.Append("append", i), name, appends.Index(i))
+			}
+			return true
+		case "Unit":
+			name := v.FieldByName("Name").String()
+			if contents := stringField(v, "Contents"); contents != nil {
+				reportSecrets(&r, p.Append("contents"), name, []byte(*contents))
+			}
+			dropins := v.FieldByName("Dropins")
+			for i := 0; i < dropins.Len(); i++ {
+				dropin := dropins.Index(i)
+				if contents := stringField(dropin, "Contents"); contents != nil {
+					dropinName := name + ".d/" + dropin.FieldByName("Name").String()
+					reportSecrets(&r, p.Append("dropins", i, "contents"), dropinName, []byte(*contents))
+				}
+			}
+			return true
+		}
+		return false
+	})
+	return
+}
+
+// secretAllowed returns true if the file path matches a pattern in the
+// allowlist.
+func secretAllowed(name string, allowlist []string) bool {
+	for _, pattern := range allowlist {
+		if matched, _ := slashpath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// scanResource reports secrets in an embedded resource at p.
+func scanResource(r *report.Report, p path.ContextPath, name string, v reflect.Value) {
+	contents, err := resourceContents(v)
+	if err != nil || contents == nil {
+		// undecodable contents are reported by validation
+		return
+	}
+	reportSecrets(r, p.Append("source"), name, contents)
+}
+
+func reportSecrets(r *report.Report, p path.ContextPath, name string, contents []byte) {
+	for _, finding := range findSecrets(contents) {
+		if finding.description != "" {
+			common.AddOn(r, p, fmt.Errorf("%s: %w (%s on line %d)", name, finding.err, finding.description, finding.line), report.Warn)
+		} else {
+			common.AddOn(r, p, fmt.Errorf("%s: %w (line %d)", name, finding.err, finding.line), report.Warn)
+		}
+	}
+}
+
+// findSecrets returns the first private key, the first credential, and
+// the first high-entropy string in contents, if any.  Binary contents
+// aren't scanned.
+func findSecrets(contents []byte) []secretFinding {
+	if !utf8.Valid(contents) || bytes.IndexByte(contents, 0) != -1 {
+		return nil
+	}
+	var privateKey, credential, entropy *secretFinding
+	inPEM := false
+	for i, line := range strings.Split(string(contents), "\n") {
+		if privateKey == nil {
+			if m := privateKeyRe.FindStringSubmatch(line); m != nil {
+				privateKey = &secretFinding{common.ErrSecretPrivateKey, m[1], i + 1}
+			}
+		}
+		// don't report credentials again as high-entropy strings
+		credentialLine := false
+		for _, c := range credentialRes {
+			if c.re.MatchString(line) {
+				credentialLine = true
+				if credential == nil {
+					credential = &secretFinding{common.ErrSecretCredential, c.description, i + 1}
+				}
+				break
+			}
+		}
+		// PEM blocks are either private keys, reported above, or
+		// public data such as certificates
+		trimmed := strings.TrimSpace(line)
+		if pemBeginRe.MatchString(trimmed) {
+			inPEM = true
+		}
+		if entropy == nil && !inPEM && !credentialLine {
+			for _, token := range tokenRe.FindAllString(line, -1) {
+				if mayBeSecret(token) {
+					entropy = &secretFinding{common.ErrSecretHighEntropy, "", i + 1}
+					break
+				}
+			}
+		}
+		if pemEndRe.MatchString(trimmed) {
+			inPEM = false
+		}
+	}
+	var ret []secretFinding
+	for _, f := range []*secretFinding{privateKey, credential, entropy} {
+		if f != nil {
+			ret = append(ret, *f)
+		}
+	}
+	return ret
+}
+
+// mayBeSecret returns true if the token looks like random data: it mixes
+// upper case, lower case, and digits, and has high entropy.  SSH public
+// keys, whose base64 encoding starts with AAAA, are excluded.
+func mayBeSecret(token string) bool {
+	if strings.HasPrefix(token, "AAAA") {
+		return false
+	}
+	if !strings.ContainsAny(token, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") ||
+		!strings.ContainsAny(token, "abcdefghijklmnopqrstuvwxyz") ||
+		!strings.ContainsAny(token, "0123456789") {
+		return false
+	}
+	counts := make(map[rune]int)
+	for _, c := range token {
+		counts[c]++
+	}
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(token))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= secretEntropyThreshold
+}
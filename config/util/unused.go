@@ -0,0 +1,182 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/coreos/vcontext/tree"
+	"github.com/coreos/vcontext/validate"
+)
+
+const unusedKeyPrefix = "Unused key "
+
+var (
+	// Ignition field names whose snake_case conversion doesn't match
+	// the corresponding Butane field name.
+	ignitionKeyNames = map[string]string{
+		"sizeMiB":  "size_mib",
+		"startMiB": "start_mib",
+	}
+)
+
+// validateUnusedKeys reports keys in the source tree that don't correspond
+// to any field in v.  It's equivalent to Ignition's ValidateUnusedKeys,
+// but suggests valid keys that the user might have meant.
+func validateUnusedKeys(v reflect.Value, c path.ContextPath, root tree.Node) (r report.Report) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	node, err := root.Get(c)
+	if err != nil {
+		// not every node will have corresponding yaml
+		return
+	}
+
+	mapNode, ok := node.(tree.MapNode)
+	if !ok {
+		// Something is wrong, we won't be able to report unused keys here, so just warn about it and stop trying
+		r.AddOn(c, fmt.Errorf("context tree does not match content tree at %s. Line and column reporting may be inconsistent. Unused keys may not be reported.", c.String()), common.CodedKind{
+			Kind: report.Warn,
+			Code: common.ContextMismatchCode,
+		})
+		return
+	}
+
+	fields := validate.GetFields(v)
+	fieldMap := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		fieldMap[validate.FieldName(field, c.Tag)] = struct{}{}
+	}
+	var keys []string
+	for key := range mapNode.Keys {
+		if _, ok := fieldMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	// report in a stable order
+	sort.Strings(keys)
+	for _, key := range keys {
+		msg := unusedKeyPrefix + key
+		if suggestions := suggestKeys(key, fieldMap); len(suggestions) > 0 {
+			msg += fmt.Sprintf("; did you mean %s?", strings.Join(suggestions, " or "))
+		}
+		r.AddOn(c.Append(tree.Key(key)), fmt.Errorf("%s", msg), common.CodedKind{
+			Kind: report.Warn,
+			Code: common.UnusedKeyCode,
+		})
+	}
+	return
+}
+
+// IsUnusedKeyEntry returns true if the report entry was produced by the
+// unused key check.
+func IsUnusedKeyEntry(e report.Entry) bool {
+	return common.EntryCode(e) == common.UnusedKeyCode
+}
+
+// suggestKeys returns the valid keys most similar to key, or nil if none
+// are similar enough to be a likely match.
+func suggestKeys(key string, valid map[string]struct{}) []string {
+	// Ignition field names, either from the explicit table or by
+	// converting camelCase to snake_case
+	if name, ok := ignitionKeyNames[key]; ok {
+		if _, ok := valid[name]; ok {
+			return []string{name}
+		}
+	}
+	if _, ok := valid[snake(key)]; ok {
+		return []string{snake(key)}
+	}
+
+	// differences in case or underscores
+	normalized := normalizeKey(key)
+	for name := range valid {
+		if normalizeKey(name) == normalized {
+			return []string{name}
+		}
+	}
+
+	// typos
+	maxDistance := len(key) / 4
+	if maxDistance < 1 {
+		maxDistance = 1
+	} else if maxDistance > 3 {
+		maxDistance = 3
+	}
+	var ret []string
+	best := maxDistance + 1
+	for name := range valid {
+		d := editDistance(normalized, normalizeKey(name))
+		if d > maxDistance {
+			continue
+		}
+		if d < best {
+			best = d
+			ret = []string{name}
+		} else if d == best {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(key, "_", ""), "-", ""))
+}
+
+// editDistance returns the optimal string alignment distance between a
+// and b: the number of insertions, deletions, substitutions, and
+// transpositions of adjacent characters needed to turn a into b.
+func editDistance(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, minInt(d[i][j-1]+1, d[i-1][j-1]+cost))
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
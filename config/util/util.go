@@ -52,20 +52,38 @@ func Translate(cfg interface{}, translateMethod string, options common.Translate
 	zeroValue := reflect.Zero(method.Type().Out(0)).Interface()
 
 	// Validate the input.
-	r := validate.Validate(cfg, "yaml")
+	r := common.AddCodes(validate.Validate(cfg, "yaml"))
 	if r.IsFatal() {
 		return zeroValue, r, common.ErrInvalidSourceConfig
 	}
 
 	// Perform the translation.
+	if options.Record != nil {
+		options.Record.NoResourceAutoCompression = options.NoResourceAutoCompression
+	}
 	translateRet := method.Call([]reflect.Value{reflect.ValueOf(options)})
 	final := translateRet[0].Interface()
 	translations := translateRet[1].Interface().(translate.TranslationSet)
 	translateReport := translateRet[2].Interface().(report.Report)
-	r.Merge(translateReport)
+	r.Merge(common.AddCodes(translateReport))
 	if r.IsFatal() {
 		return zeroValue, r, common.ErrInvalidSourceConfig
 	}
+	if options.EmbedRemote || options.PinRemote {
+		v := reflect.New(reflect.TypeOf(final)).Elem()
+		v.Set(reflect.ValueOf(final))
+		r.Merge(TranslateReportPaths(fetchRemoteResources(v, translations, options), translations))
+		if r.IsFatal() {
+			return zeroValue, r, common.ErrInvalidSourceConfig
+		}
+		final = v.Interface()
+	}
+	if options.ScanSecrets {
+		r.Merge(TranslateReportPaths(scanSecrets(reflect.ValueOf(final), options), translations))
+	}
+	if options.Record != nil {
+		options.Record.Translations = translations
+	}
 	if options.DebugPrintTranslations {
 		fmt.Fprint(os.Stderr, translations)
 		if err := translations.DebugVerifyCoverage(final); err != nil {
@@ -104,20 +122,24 @@ func TranslateBytes(input []byte, container interface{}, translateMethod string,
 
 	// Check for unused keys.
 	unusedKeyCheck := func(v reflect.Value, c path.ContextPath) report.Report {
-		return ignvalidate.ValidateUnusedKeys(v, c, contextTree)
+		return validateUnusedKeys(v, c, contextTree)
 	}
 	r := validate.ValidateCustom(cfg, "yaml", unusedKeyCheck)
 	r.Correlate(contextTree)
 	if r.IsFatal() {
 		return nil, r, common.ErrInvalidSourceConfig
 	}
+	if options.Record != nil {
+		options.Record.Source = contextTree
+		options.Record.Config = input
+	}
 
 	// Perform the translation.
 	translateRet := reflect.ValueOf(cfg).MethodByName(translateMethod).Call([]reflect.Value{reflect.ValueOf(options.TranslateOptions)})
 	final := translateRet[0].Interface()
-	translateReport := translateRet[1].Interface().(report.Report)
+	translateReport := common.AddCodes(translateRet[1].Interface().(report.Report))
 	errVal := translateRet[2]
-	translateReport.Correlate(contextTree)
+	correlate(&translateReport, contextTree)
 	r.Merge(translateReport)
 	if !errVal.IsNil() {
 		return nil, r, errVal.Interface().(error)
@@ -217,11 +239,27 @@ func snake(in string) string {
 	return strings.ToLower(snakeRe.ReplaceAllString(in, "_$1"))
 }
 
+// correlate sets the markers of report entries from the context tree of
+// the config, keeping markers that translators set to point into other
+// files, such as templates.
+func correlate(r *report.Report, contextTree tree.Node) {
+	for i, e := range r.Entries {
+		if e.Marker.StartP != nil {
+			continue
+		}
+		single := report.Report{
+			Entries: []report.Entry{e},
+		}
+		single.Correlate(contextTree)
+		r.Entries[i] = single.Entries[0]
+	}
+}
+
 // TranslateReportPaths takes a report from a camelCase json document and a set of translations rules,
-// applies those rules and converts all camelCase to snake_case.
+// applies those rules and converts all camelCase to snake_case.  It also records the code of each
+// entry, since the report usually comes from Ignition validation.
 func TranslateReportPaths(r report.Report, ts translate.TranslationSet) report.Report {
-	var ret report.Report
-	ret.Merge(r)
+	ret := common.AddCodes(r)
 	for i, ent := range ret.Entries {
 		context := ent.Context
 		if t, ok := ts.Set[context.String()]; ok {
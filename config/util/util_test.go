@@ -70,7 +70,12 @@ func TestTranslateReportPaths(t *testing.T) {
 		} else {
 			p = path.New("json", "d", "e", "f")
 		}
-		r.AddOnError(p, common.ErrDecimalMode)
+		if source {
+			// TranslateReportPaths records the code
+			common.AddOn(&r, p, common.ErrDecimalMode, report.Error)
+		} else {
+			r.AddOnError(p, common.ErrDecimalMode)
+		}
 		return r
 	}
 	r := makeReport(false)
@@ -78,3 +83,59 @@ func TestTranslateReportPaths(t *testing.T) {
 	assert.Equal(t, makeReport(false), r, "TranslateReportPaths changed original report")
 	assert.Equal(t, makeReport(true), r2, "TranslateReportPaths returned incorrect report")
 }
+
+func TestSuggestKeys(t *testing.T) {
+	valid := map[string]struct{}{
+		"device":              {},
+		"partitions":          {},
+		"size_mib":            {},
+		"ssh_authorized_keys": {},
+		"wipe_table":          {},
+	}
+	tests := []struct {
+		in  string
+		out []string
+	}{
+		// unrelated
+		{
+			"q",
+			nil,
+		},
+		// Ignition camelCase
+		{
+			"wipeTable",
+			[]string{"wipe_table"},
+		},
+		// Ignition camelCase, explicit mapping
+		{
+			"sizeMiB",
+			[]string{"size_mib"},
+		},
+		// case difference
+		{
+			"Device",
+			[]string{"device"},
+		},
+		// typo
+		{
+			"ssh_authorised_keys",
+			[]string{"ssh_authorized_keys"},
+		},
+		// transposition
+		{
+			"devcie",
+			[]string{"device"},
+		},
+		// too different
+		{
+			"devicesss",
+			nil,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			assert.Equal(t, test.out, suggestKeys(test.in, valid), "bad suggestions")
+		})
+	}
+}
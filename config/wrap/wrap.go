@@ -0,0 +1,179 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package wrap encloses translated Ignition configs in the envelopes that
+// platforms use to deliver them to machines.
+package wrap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNotIgnition = errors.New("only Ignition configs can be wrapped; use --raw for MachineConfig variants")
+
+	// registry is guarded by registryLock so wrappers can be
+	// registered while wrapping is in progress
+	registry     = map[string]Wrapper{}
+	registryLock sync.RWMutex
+)
+
+// Wrapper encloses an Ignition config in a platform-specific envelope.
+type Wrapper func(config []byte, options Options) ([]byte, error)
+
+// Options are settings for wrappers.
+type Options struct {
+	// Name is the name of the wrapping object, for wrappers that
+	// create one.  Defaults to "userdata".
+	Name string
+}
+
+func init() {
+	Register("base64", wrapBase64)
+	Register("kubernetes", wrapKubernetes)
+	Register("libvirt", wrapLibvirt)
+	Register("qemu", wrapQemu)
+	Register("vmware", wrapVMware)
+}
+
+// Register registers a wrapper for the specified platform.  It panics if
+// the platform already has a wrapper.
+func Register(platform string, wrapper Wrapper) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[platform]; ok {
+		panic("tried to reregister existing wrapper")
+	}
+	registry[platform] = wrapper
+}
+
+// Platforms returns the platforms with registered wrappers, sorted by
+// name.
+func Platforms() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	ret := make([]string, 0, len(registry))
+	for platform := range registry {
+		ret = append(ret, platform)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Wrap encloses config, a translated Ignition config, in the envelope for
+// the specified platform.  Like translated configs, the result has no
+// trailing newline.
+func Wrap(platform string, config []byte, options Options) ([]byte, error) {
+	registryLock.RLock()
+	wrapper, ok := registry[platform]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("No wrapper exists for platform %s; valid platforms: %s", platform, strings.Join(Platforms(), ", "))
+	}
+	if !json.Valid(config) {
+		return nil, ErrNotIgnition
+	}
+	if options.Name == "" {
+		options.Name = "userdata"
+	}
+	return wrapper(config, options)
+}
+
+// wrapBase64 returns the config encoded in base64, as accepted by many
+// cloud userdata fields.
+func wrapBase64(config []byte, options Options) ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(config)), nil
+}
+
+// wrapKubernetes returns a Secret with the config in its userData key,
+// for use as a Cluster API bootstrap data secret.
+func wrapKubernetes(config []byte, options Options) ([]byte, error) {
+	name, err := json.Marshal(options.Name)
+	if err != nil {
+		return nil, err
+	}
+	return joinLines(
+		"apiVersion: v1",
+		"kind: Secret",
+		"metadata:",
+		"  name: "+string(name),
+		"type: Opaque",
+		"data:",
+		"  userData: "+base64.StdEncoding.EncodeToString(config),
+	), nil
+}
+
+// wrapLibvirt returns a libvirt domain XML snippet passing the config to
+// QEMU via fw_cfg.
+func wrapLibvirt(config []byte, options Options) ([]byte, error) {
+	var value bytes.Buffer
+	if err := xml.EscapeText(&value, []byte(fwCfgArg(config))); err != nil {
+		return nil, err
+	}
+	return joinLines(
+		`<qemu:commandline xmlns:qemu="http://libvirt.org/schemas/domain/qemu/1.0">`,
+		`  <qemu:arg value="-fw_cfg"/>`,
+		`  <qemu:arg value="`+value.String()+`"/>`,
+		`</qemu:commandline>`,
+	), nil
+}
+
+// wrapQemu returns QEMU command-line arguments, quoted for a POSIX shell,
+// passing the config via fw_cfg.
+func wrapQemu(config []byte, options Options) ([]byte, error) {
+	return []byte("-fw_cfg " + shellQuote(fwCfgArg(config))), nil
+}
+
+// wrapVMware returns VMware guestinfo properties, in .vmx syntax, with the
+// config gzipped and base64-encoded.
+func wrapVMware(config []byte, options Options) ([]byte, error) {
+	var gz bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(config); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return joinLines(
+		fmt.Sprintf("guestinfo.ignition.config.data = %q", base64.StdEncoding.EncodeToString(gz.Bytes())),
+		fmt.Sprintf("guestinfo.ignition.config.data.encoding = %q", "gzip+base64"),
+	), nil
+}
+
+// fwCfgArg returns the value of a QEMU -fw_cfg argument providing the
+// config, with commas escaped by doubling.
+func fwCfgArg(config []byte) string {
+	return "name=opt/com.coreos/config,string=" + strings.ReplaceAll(string(config), ",", ",,")
+}
+
+func joinLines(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package wrap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrap(t *testing.T) {
+	config := []byte(`{"ignition":{"version":"3.3.0"},"a":"it's"}`)
+	b64 := base64.StdEncoding.EncodeToString(config)
+	tests := []struct {
+		platform string
+		options  Options
+		out      string
+		err      error
+	}{
+		{
+			"base64",
+			Options{},
+			b64,
+			nil,
+		},
+		{
+			"kubernetes",
+			Options{},
+			"apiVersion: v1\nkind: Secret\nmetadata:\n  name: \"userdata\"\ntype: Opaque\ndata:\n  userData: " + b64,
+			nil,
+		},
+		{
+			"kubernetes",
+			Options{Name: "worker-0"},
+			"apiVersion: v1\nkind: Secret\nmetadata:\n  name: \"worker-0\"\ntype: Opaque\ndata:\n  userData: " + b64,
+			nil,
+		},
+		{
+			"qemu",
+			Options{},
+			`-fw_cfg 'name=opt/com.coreos/config,string={"ignition":{"version":"3.3.0"},,"a":"it'\''s"}'`,
+			nil,
+		},
+		{
+			"libvirt",
+			Options{},
+			"<qemu:commandline xmlns:qemu=\"http://libvirt.org/schemas/domain/qemu/1.0\">\n" +
+				"  <qemu:arg value=\"-fw_cfg\"/>\n" +
+				"  <qemu:arg value=\"name=opt/com.coreos/config,string={&#34;ignition&#34;:{&#34;version&#34;:&#34;3.3.0&#34;},,&#34;a&#34;:&#34;it&#39;s&#34;}\"/>\n" +
+				"</qemu:commandline>",
+			nil,
+		},
+		{
+			"nonexistent",
+			Options{},
+			"",
+			fmt.Errorf("No wrapper exists for platform nonexistent; valid platforms: base64, kubernetes, libvirt, qemu, vmware"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("wrap %d", i), func(t *testing.T) {
+			out, err := Wrap(test.platform, config, test.options)
+			assert.Equal(t, test.err, err, "bad error")
+			assert.Equal(t, test.out, string(out), "bad output")
+		})
+	}
+}
+
+func TestWrapVMware(t *testing.T) {
+	config := []byte(`{"ignition":{"version":"3.3.0"}}`)
+	out, err := Wrap("vmware", config, Options{})
+	assert.NoError(t, err)
+	lines := strings.Split(string(out), "\n")
+	assert.Equal(t, 2, len(lines), "bad line count")
+	assert.Equal(t, `guestinfo.ignition.config.data.encoding = "gzip+base64"`, lines[1], "bad encoding property")
+	prefix := `guestinfo.ignition.config.data = "`
+	if !strings.HasPrefix(lines[0], prefix) || !strings.HasSuffix(lines[0], `"`) {
+		t.Fatalf("bad data property: %s", lines[0])
+	}
+	gz, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(lines[0], prefix), `"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, config, decoded, "bad decoded config")
+}
+
+func TestWrapNotIgnition(t *testing.T) {
+	for i, platform := range Platforms() {
+		t.Run(fmt.Sprintf("not ignition %d", i), func(t *testing.T) {
+			_, err := Wrap(platform, []byte("kind: MachineConfig\n"), Options{})
+			assert.Equal(t, ErrNotIgnition, err, "bad error")
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package annotate renders a translated config with comments describing
+// where each field came from in the Butane config.
+package annotate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"gopkg.in/yaml.v3"
+)
+
+// Annotate pretty-prints the output config produced by a translation,
+// adding a comment to each field that names its source path and line in
+// the Butane config.  Fields synthesized by sugar are marked as generated.
+// JSON output is rendered as JSONC; YAML output keeps YAML syntax.
+func Annotate(output []byte, record *common.TranslationRecord) ([]byte, error) {
+	if json.Valid(output) {
+		return annotateJSON(output, record)
+	}
+	return annotateYAML(output, record)
+}
+
+// comment returns the annotation for the specified output path, or the
+// empty string if the path has no known source.
+func comment(record *common.TranslationRecord, p path.ContextPath) string {
+	from, line, ok := record.SourceOf(p)
+	if !ok {
+		return ""
+	}
+	loc := from.String()
+	if line > 0 {
+		loc += fmt.Sprintf(", line %d", line)
+	}
+	if isGenerated(from, p) {
+		return "generated from " + loc
+	}
+	return loc
+}
+
+// isGenerated returns true if the translation doesn't correspond to a
+// field at the same path in the source config.  List indexes are ignored,
+// since sugar and config merging renumber list entries, and MachineConfig
+// output is compared without the spec.config that wraps the Ignition
+// config.  Resource contents such as inline or local are rendered into the
+// source and compression fields of the same resource, which isn't
+// considered generated.
+func isGenerated(from, to path.ContextPath) bool {
+	fromNames := names(from)
+	toNames := names(to)
+	if len(toNames) > len(machineConfigPrefix) && equal(toNames[:len(machineConfigPrefix)], machineConfigPrefix) {
+		toNames = toNames[len(machineConfigPrefix):]
+	}
+	if equal(fromNames, toNames) {
+		return false
+	}
+	if len(fromNames) == 0 || len(fromNames) != len(toNames) {
+		return true
+	}
+	last := len(fromNames) - 1
+	return !equal(fromNames[:last], toNames[:last]) ||
+		!resourceContentsFields[fromNames[last]] ||
+		!resourceOutputFields[toNames[last]]
+}
+
+// the path of the Ignition config within a MachineConfig
+var machineConfigPrefix = []string{"spec", "config"}
+
+var (
+	// resource fields that specify contents
+	resourceContentsFields = map[string]bool{
+		"inline": true,
+		"local":  true,
+		"json":   true,
+		"yaml":   true,
+		"toml":   true,
+		"ini":    true,
+	}
+	// resource fields produced from contents
+	resourceOutputFields = map[string]bool{
+		"source":      true,
+		"compression": true,
+	}
+)
+
+// names returns the normalized names in p, omitting list indexes.
+func names(p path.ContextPath) []string {
+	var ret []string
+	for _, e := range p.Path {
+		if name, ok := e.(string); ok {
+			ret = append(ret, normalize(name))
+		}
+	}
+	return ret
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize folds the differences between snake_case and camelCase.
+func normalize(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// jsonWriter writes JSONC.  Comments can't precede the comma that
+// separates members, so the comment for each member is held until the
+// next separator has been written.
+type jsonWriter struct {
+	buf     bytes.Buffer
+	record  *common.TranslationRecord
+	pending string
+}
+
+func annotateJSON(output []byte, record *common.TranslationRecord) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(output))
+	dec.UseNumber()
+	w := jsonWriter{
+		record: record,
+	}
+	if err := w.writeValue(dec, path.New("json"), ""); err != nil {
+		return nil, err
+	}
+	w.flushComment()
+	return w.buf.Bytes(), nil
+}
+
+// writeValue writes the next value from dec, commented with its source.
+// Comments for objects and arrays follow the opening delimiter.
+func (w *jsonWriter) writeValue(dec *json.Decoder, p path.ContextPath, indent string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		if err := w.writeJSON(tok); err != nil {
+			return err
+		}
+		w.pending = comment(w.record, p)
+		return nil
+	}
+
+	closing := "}"
+	if delim == '[' {
+		closing = "]"
+	}
+	w.buf.WriteString(delim.String())
+	w.pending = comment(w.record, p)
+	childIndent := indent + "  "
+	first := true
+	for i := 0; dec.More(); i++ {
+		var childPath path.ContextPath
+		var key string
+		if delim == '{' {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key = tok.(string)
+			childPath = p.Append(key).Copy()
+		} else {
+			childPath = p.Append(i).Copy()
+		}
+		if !first {
+			w.buf.WriteString(",")
+		}
+		w.flushComment()
+		w.buf.WriteString("\n" + childIndent)
+		if delim == '{' {
+			if err := w.writeJSON(key); err != nil {
+				return err
+			}
+			w.buf.WriteString(": ")
+		}
+		if err := w.writeValue(dec, childPath, childIndent); err != nil {
+			return err
+		}
+		first = false
+	}
+	// consume closing delimiter
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if !first {
+		w.flushComment()
+		w.buf.WriteString("\n" + indent)
+	}
+	// for an empty object or array, our own comment is still pending
+	w.buf.WriteString(closing)
+	return nil
+}
+
+func (w *jsonWriter) writeJSON(v interface{}) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.buf.Write(encoded)
+	return nil
+}
+
+func (w *jsonWriter) flushComment() {
+	if w.pending != "" {
+		w.buf.WriteString(" // " + w.pending)
+	}
+	w.pending = ""
+}
+
+func annotateYAML(output []byte, record *common.TranslationRecord) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(output, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) > 0 {
+		annotateYAMLNode(doc.Content[0], record, path.New("json"))
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func annotateYAMLNode(node *yaml.Node, record *common.TranslationRecord, p path.ContextPath) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			value := node.Content[i+1]
+			childPath := p.Append(key.Value).Copy()
+			if c := comment(record, childPath); c != "" {
+				if value.Kind == yaml.ScalarNode {
+					value.LineComment = c
+				} else {
+					key.LineComment = c
+				}
+			}
+			annotateYAMLNode(value, record, childPath)
+		}
+	case yaml.SequenceNode:
+		for i, value := range node.Content {
+			childPath := p.Append(i).Copy()
+			if c := comment(record, childPath); c != "" {
+				if value.Kind == yaml.ScalarNode {
+					value.LineComment = c
+				} else {
+					value.HeadComment = c
+				}
+			}
+			annotateYAMLNode(value, record, childPath)
+		}
+	}
+}
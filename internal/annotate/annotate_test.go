@@ -0,0 +1,151 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package annotate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotate(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		// Ignition output, with sugar
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /z
+      mode: 0600
+  filesystems:
+    - device: /dev/vda
+      path: /var
+      format: xfs
+      with_mount_unit: true`,
+			`{
+  "ignition": { // $.ignition
+    "version": "3.3.0" // generated from $.version, line 2
+  },
+  "storage": { // $.storage, line 3
+    "files": [ // $.storage.files, line 4
+      { // $.storage.files.0, line 5
+        "path": "/z", // $.storage.files.0.path, line 5
+        "mode": 384 // $.storage.files.0.mode, line 6
+      }
+    ],
+    "filesystems": [ // $.storage.filesystems, line 7
+      { // $.storage.filesystems.0, line 8
+        "device": "/dev/vda", // $.storage.filesystems.0.device, line 8
+        "format": "xfs", // $.storage.filesystems.0.format, line 10
+        "path": "/var" // $.storage.filesystems.0.path, line 9
+      }
+    ]
+  },
+  "systemd": { // generated from $.storage.filesystems, line 7
+    "units": [ // generated from $.storage.filesystems, line 7
+      { // generated from $.storage.filesystems.0.with_mount_unit, line 11
+        "contents": "# Generated by Butane\n[Unit]\nBefore=local-fs.target\nRequires=systemd-fsck@dev-vda.service\nAfter=systemd-fsck@dev-vda.service\n\n[Mount]\nWhere=/var\nWhat=/dev/vda\nType=xfs\n\n[Install]\nRequiredBy=local-fs.target", // generated from $.storage.filesystems.0.with_mount_unit, line 11
+        "enabled": true, // generated from $.storage.filesystems.0.with_mount_unit, line 11
+        "name": "var.mount" // generated from $.storage.filesystems.0.with_mount_unit, line 11
+      }
+    ]
+  }
+}`,
+		},
+		// resource contents
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /z
+      contents:
+        inline: z`,
+			`{
+  "ignition": { // $.ignition
+    "version": "3.3.0" // generated from $.version, line 2
+  },
+  "storage": { // $.storage, line 3
+    "files": [ // $.storage.files, line 4
+      { // $.storage.files.0, line 5
+        "path": "/z", // $.storage.files.0.path, line 5
+        "contents": { // $.storage.files.0.contents, line 6
+          "compression": "", // $.storage.files.0.contents.inline, line 7
+          "source": "data:,z" // $.storage.files.0.contents.inline, line 7
+        }
+      }
+    ]
+  }
+}`,
+		},
+		// MachineConfig output
+		{
+			`variant: openshift
+version: 4.10.0
+metadata:
+  name: z
+  labels:
+    machineconfiguration.openshift.io/role: worker
+openshift:
+  fips: true
+storage:
+  luks:
+    - name: z
+      device: /dev/vda`,
+			`# Generated by Butane; do not edit
+apiVersion: machineconfiguration.openshift.io/v1 # generated from $.version, line 2
+kind: MachineConfig # generated from $.version, line 2
+metadata: # $.metadata, line 3
+  labels: # $.metadata.labels, line 5
+    machineconfiguration.openshift.io/role: worker # $.metadata.labels.machineconfiguration.openshift.io/role, line 6
+  name: z # $.metadata.name, line 4
+spec: # generated from $.version, line 2
+  config: # generated from $, line 1
+    ignition: # $.ignition
+      version: 3.2.0 # generated from $.version, line 2
+    storage: # $.storage, line 9
+      luks: # $.storage.luks, line 10
+        # $.storage.luks.0, line 11
+        - device: /dev/vda # $.storage.luks.0.device, line 12
+          name: z # $.storage.luks.0.name, line 11
+          options: # generated from $.openshift.fips, line 8
+            - --cipher # generated from $.openshift.fips, line 8
+            - aes-cbc-essiv:sha256 # generated from $.openshift.fips, line 8
+  fips: true # generated from $.openshift.fips, line 8`,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("annotate %d", i), func(t *testing.T) {
+			options := common.TranslateBytesOptions{}
+			options.Record = &common.TranslationRecord{}
+			out, _, err := config.TranslateBytes([]byte(test.in), options)
+			if !assert.NoError(t, err, "translation failed") {
+				return
+			}
+			annotated, err := Annotate(out, options.Record)
+			assert.NoError(t, err, "annotation failed")
+			assert.Equal(t, test.out, string(annotated), "bad annotation")
+		})
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package budget accounts for the size of a translated config and the
+// Butane config fields that contribute to it.
+package budget
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/internal/outputdoc"
+
+	"github.com/coreos/vcontext/path"
+	"gopkg.in/yaml.v3"
+)
+
+// Presets are the size limits, in bytes, of common delivery targets.
+var Presets = map[string]int{
+	// EC2 user data, before base64 encoding
+	"aws": 16 * 1024,
+	// Azure custom data
+	"azure": 64 * 1024,
+	// a single GCE metadata value
+	"gcp": 256 * 1024,
+	// a MachineConfig, well below etcd's default 1.5 MiB request
+	// limit since MCO renders all MachineConfigs into one object
+	"openshift-mc": 1024 * 1024,
+}
+
+// Contribution kinds
+const (
+	KindFile      = "file"
+	KindDirectory = "directory"
+	KindLink      = "link"
+	KindTree      = "tree"
+	KindUnit      = "unit"
+)
+
+// Usage is the size of a translated config and of the entries in it.
+type Usage struct {
+	// Total is the size of the output in bytes.
+	Total int
+	// Contributions lists the entries in the output, largest first.
+	// Nodes created from a tree are combined into one contribution.
+	Contributions []Contribution
+	// MachineConfig is true if the output is a MachineConfig, whose
+	// contents can't be hosted with --split-dir.
+	MachineConfig bool
+	// NoResourceAutoCompression is true if the translation didn't
+	// compress embedded contents that would have shrunk with gzip.
+	NoResourceAutoCompression bool
+}
+
+// Contribution is the size of an entry in the output, measured as
+// compact JSON, with the Butane config field that produced it.
+type Contribution struct {
+	Kind string
+	// Path is the path of a filesystem node, the name of a unit, or
+	// the local directory of a tree.
+	Path string
+	// Nodes is the number of nodes created from a tree.
+	Nodes int
+	Size  int
+	// Source is the Butane config field that produced the entry, if
+	// known, and Line is its line in the config, or 0 if unknown.
+	Source *path.ContextPath
+	Line   int64
+	// Compressed is true if all contents of the entry are compressed.
+	Compressed bool
+	// Embedded is true if any contents of the entry are embedded in
+	// the config as data URLs.
+	Embedded bool
+}
+
+// PresetNames returns the names of the presets, sorted.
+func PresetNames() []string {
+	ret := make([]string, 0, len(Presets))
+	for name := range Presets {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ParseLimit parses a size limit, which is either the name of a preset
+// or a number of bytes with an optional K or M suffix (powers of 1024).
+// It returns the limit and a description of it.
+func ParseLimit(s string) (int, string, error) {
+	if limit, ok := Presets[s]; ok {
+		return limit, s + " limit", nil
+	}
+	num := strings.TrimSuffix(strings.TrimSuffix(s, "iB"), "B")
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(num, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(num, "M"):
+		multiplier = 1024 * 1024
+	}
+	if multiplier != 1 {
+		num = num[:len(num)-1]
+	}
+	limit, err := strconv.Atoi(num)
+	if err != nil || limit <= 0 {
+		return 0, "", fmt.Errorf("invalid size limit %q; specify a number of bytes or one of: %s", s, strings.Join(PresetNames(), ", "))
+	}
+	return limit * multiplier, "limit", nil
+}
+
+type measurer struct {
+	record *common.TranslationRecord
+	// the Butane config
+	config interface{}
+	// path to the Ignition config within the output
+	ignPath []interface{}
+	// indexes of tree contributions, by source path
+	trees map[string]int
+	usage Usage
+}
+
+// Measure returns the size usage of the output of a translation.  record
+// must be the TranslationRecord of the translation.
+func Measure(output []byte, record *common.TranslationRecord) (*Usage, error) {
+	doc, err := outputdoc.Parse(output)
+	if err != nil {
+		return nil, fmt.Errorf("parsing output: %w", err)
+	}
+	m := measurer{
+		record: record,
+		trees:  make(map[string]int),
+		usage: Usage{
+			Total:                     len(output),
+			NoResourceAutoCompression: record.NoResourceAutoCompression,
+		},
+	}
+	if err := yaml.Unmarshal(record.Config, &m.config); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	var ign map[string]interface{}
+	ign, m.ignPath = outputdoc.Ignition(doc)
+	m.usage.MachineConfig = len(m.ignPath) > 0
+
+	storage := outputdoc.Map(ign, "storage")
+	for _, kind := range []string{KindFile, KindDirectory, KindLink} {
+		key := kind + "s"
+		if kind == KindDirectory {
+			key = "directories"
+		}
+		for i, v := range outputdoc.List(storage, key) {
+			node, _ := v.(map[string]interface{})
+			nodePath, _ := node["path"].(string)
+			c := Contribution{
+				Kind: kind,
+				Path: nodePath,
+			}
+			compressed := true
+			for _, res := range append([]interface{}{node["contents"]}, outputdoc.List(node, "append")...) {
+				res, _ := res.(map[string]interface{})
+				if source, _ := res["source"].(string); strings.HasPrefix(source, "data:") {
+					c.Embedded = true
+					if s, _ := res["compression"].(string); s == "" {
+						compressed = false
+					}
+				}
+			}
+			c.Compressed = c.Embedded && compressed
+			if err := m.add(c, v, "storage", key, i, "path"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, v := range outputdoc.List(outputdoc.Map(ign, "systemd"), "units") {
+		unit, _ := v.(map[string]interface{})
+		name, _ := unit["name"].(string)
+		c := Contribution{
+			Kind:     KindUnit,
+			Path:     name,
+			Embedded: true,
+		}
+		if err := m.add(c, v, "systemd", "units", i, "name"); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(m.usage.Contributions, func(i, j int) bool {
+		return m.usage.Contributions[i].Size > m.usage.Contributions[j].Size
+	})
+	return &m.usage, nil
+}
+
+// add records the contribution of the entry v, given the path of its key
+// field relative to the Ignition config.
+func (m *measurer) add(c Contribution, v interface{}, p ...interface{}) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.Size = len(encoded)
+	full := append(append([]interface{}{}, m.ignPath...), p...)
+	if t, ok := m.record.Translations.Set[path.New("json", full...).String()]; ok && len(t.From.Path) > 0 {
+		from := t.From
+		if len(from.Path) == 3 && from.Path[0] == "storage" && from.Path[1] == "trees" {
+			// combine the nodes of a tree
+			key := from.String()
+			index, ok := m.trees[key]
+			if !ok {
+				local, _ := outputdoc.Value(m.config, from.Append("local")).(string)
+				m.usage.Contributions = append(m.usage.Contributions, Contribution{
+					Kind:       KindTree,
+					Path:       local,
+					Source:     &from,
+					Line:       m.record.SourceLine(from),
+					Compressed: true,
+				})
+				index = len(m.usage.Contributions) - 1
+				m.trees[key] = index
+			}
+			tree := &m.usage.Contributions[index]
+			tree.Nodes++
+			tree.Size += c.Size
+			tree.Embedded = tree.Embedded || c.Embedded
+			tree.Compressed = tree.Compressed && (c.Compressed || !c.Embedded)
+			return nil
+		}
+		if last, _ := from.Path[len(from.Path)-1].(string); last == "path" || last == "name" {
+			from = from.Copy().Pop()
+		}
+		c.Source = &from
+		c.Line = m.record.SourceLine(from)
+	}
+	m.usage.Contributions = append(m.usage.Contributions, c)
+	return nil
+}
+
+// Report describes the usage relative to the specified limit, if it's
+// positive, listing up to count of the largest contributions.  If the
+// limit is exceeded, it suggests ways to shrink the largest contributions
+// that together account for the excess.
+func (u *Usage) Report(limit int, description string, count int) string {
+	var buf bytes.Buffer
+	if limit <= 0 {
+		fmt.Fprintf(&buf, "config is %d bytes\n", u.Total)
+	} else if u.Total > limit {
+		fmt.Fprintf(&buf, "config is %d bytes, exceeding the %s of %d bytes by %d bytes\n", u.Total, description, limit, u.Total-limit)
+	} else {
+		fmt.Fprintf(&buf, "config is %d bytes, within the %s of %d bytes\n", u.Total, description, limit)
+	}
+	if count > len(u.Contributions) {
+		count = len(u.Contributions)
+	}
+	if count > 0 {
+		fmt.Fprintf(&buf, "largest contributors:\n")
+	}
+	excess := u.Total - limit
+	for _, c := range u.Contributions[:count] {
+		fmt.Fprintf(&buf, "  %8d  %s\n", c.Size, c.describe())
+		if limit > 0 && excess > 0 {
+			if suggestion := c.suggestion(u); suggestion != "" {
+				fmt.Fprintf(&buf, "            %s\n", suggestion)
+			}
+			excess -= c.Size
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (c Contribution) describe() string {
+	desc := c.Kind + " " + c.Path
+	if c.Kind == KindTree {
+		nodes := "nodes"
+		if c.Nodes == 1 {
+			nodes = "node"
+		}
+		desc = fmt.Sprintf("tree %s (%d %s)", c.Path, c.Nodes, nodes)
+	}
+	if c.Source != nil {
+		desc += " from " + c.Source.String()
+		if c.Line > 0 {
+			desc += fmt.Sprintf(", line %d", c.Line)
+		}
+	}
+	return desc
+}
+
+// suggestion returns a way to shrink the contribution, if there is one.
+func (c Contribution) suggestion(u *Usage) string {
+	switch {
+	case c.Kind == KindUnit:
+		return "unit contents can't be compressed or hosted; move large contents into a file"
+	case !c.Embedded:
+		return ""
+	case c.Kind == KindTree && u.MachineConfig:
+		return "exclude unneeded files"
+	case c.Kind == KindTree:
+		return "exclude unneeded files, or host the largest files with --split-dir"
+	case !c.Compressed && u.NoResourceAutoCompression && u.MachineConfig:
+		return "set compression: gzip, which MachineConfigs for OpenShift 4.10 and later support"
+	case !c.Compressed && u.NoResourceAutoCompression:
+		return "set compression: gzip, or host the contents with --split-dir"
+	case u.MachineConfig:
+		// nothing else shrinks embedded contents
+		return ""
+	case !c.Compressed:
+		// Butane compresses embedded contents if that makes them
+		// smaller, so these contents are incompressible
+		return "host the contents with --split-dir; they don't shrink with gzip"
+	default:
+		return "host the contents with --split-dir"
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package budget
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in          string
+		limit       int
+		description string
+		err         error
+	}{
+		{"aws", 16384, "aws limit", nil},
+		{"openshift-mc", 1048576, "openshift-mc limit", nil},
+		{"1000", 1000, "limit", nil},
+		{"16K", 16384, "limit", nil},
+		{"16KiB", 16384, "limit", nil},
+		{"2M", 2097152, "limit", nil},
+		{"2MB", 2097152, "limit", nil},
+		{"0", 0, "", fmt.Errorf(`invalid size limit "0"; specify a number of bytes or one of: aws, azure, gcp, openshift-mc`)},
+		{"K", 0, "", fmt.Errorf(`invalid size limit "K"; specify a number of bytes or one of: aws, azure, gcp, openshift-mc`)},
+		{"ibm", 0, "", fmt.Errorf(`invalid size limit "ibm"; specify a number of bytes or one of: aws, azure, gcp, openshift-mc`)},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("parse %d", i), func(t *testing.T) {
+			limit, description, err := ParseLimit(test.in)
+			assert.Equal(t, test.err, err, "bad error")
+			assert.Equal(t, test.limit, limit, "bad limit")
+			assert.Equal(t, test.description, description, "bad description")
+		})
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	filesDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(filesDir, "tree", "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"tree/etc/a": strings.Repeat("a", 1000),
+		"tree/etc/b": "b",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(filesDir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		in  string
+		out []Contribution
+	}{
+		// empty config
+		{
+			"variant: fcos\nversion: 1.4.0",
+			nil,
+		},
+		// files, links, units, and a tree
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /small
+      contents:
+        inline: "1"
+    - path: /large
+      contents:
+        inline: ` + strings.Repeat("z", 2000) + `
+    - path: /remote
+      contents:
+        source: https://example.com/
+  links:
+    - path: /link
+      target: /small
+  trees:
+    - local: tree
+systemd:
+  units:
+    - name: a.service
+      contents: "[Unit]\n"`,
+			[]Contribution{
+				{
+					Kind:     KindTree,
+					Path:     "tree",
+					Nodes:    2,
+					Size:     204,
+					Source:   contextPath("storage", "trees", 0),
+					Line:     18,
+					Embedded: true,
+				},
+				{
+					Kind:       KindFile,
+					Path:       "/large",
+					Size:       124,
+					Source:     contextPath("storage", "files", 1),
+					Line:       8,
+					Compressed: true,
+					Embedded:   true,
+				},
+				{
+					Kind:     KindFile,
+					Path:     "/small",
+					Size:     66,
+					Source:   contextPath("storage", "files", 0),
+					Line:     5,
+					Embedded: true,
+				},
+				{
+					Kind:   KindFile,
+					Path:   "/remote",
+					Size:   63,
+					Source: contextPath("storage", "files", 2),
+					Line:   11,
+				},
+				{
+					Kind:     KindUnit,
+					Path:     "a.service",
+					Size:     42,
+					Source:   contextPath("systemd", "units", 0),
+					Line:     21,
+					Embedded: true,
+				},
+				{
+					Kind:   KindLink,
+					Path:   "/link",
+					Size:   34,
+					Source: contextPath("storage", "links", 0),
+					Line:   15,
+				},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("measure %d", i), func(t *testing.T) {
+			options := common.TranslateBytesOptions{}
+			options.FilesDir = filesDir
+			options.Record = &common.TranslationRecord{}
+			out, _, err := config.TranslateBytes([]byte(test.in), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			usage, err := Measure(out, options.Record)
+			assert.NoError(t, err)
+			assert.Equal(t, len(out), usage.Total, "bad total")
+			assert.Equal(t, test.out, usage.Contributions, "bad contributions")
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	usage := Usage{
+		Total: 3000,
+		Contributions: []Contribution{
+			{
+				Kind:     KindTree,
+				Path:     "tree",
+				Nodes:    3,
+				Size:     1500,
+				Source:   contextPath("storage", "trees", 0),
+				Line:     5,
+				Embedded: true,
+			},
+			{
+				Kind:     KindFile,
+				Path:     "/a",
+				Size:     1000,
+				Source:   contextPath("storage", "files", 0),
+				Line:     3,
+				Embedded: true,
+			},
+			{
+				Kind:     KindUnit,
+				Path:     "a.service",
+				Size:     400,
+				Embedded: true,
+			},
+		},
+	}
+	tests := []struct {
+		limit int
+		count int
+		out   string
+	}{
+		{
+			0,
+			1,
+			"config is 3000 bytes\n" +
+				"largest contributors:\n" +
+				"      1500  tree tree (3 nodes) from $.storage.trees.0, line 5",
+		},
+		{
+			4000,
+			10,
+			"config is 3000 bytes, within the limit of 4000 bytes\n" +
+				"largest contributors:\n" +
+				"      1500  tree tree (3 nodes) from $.storage.trees.0, line 5\n" +
+				"      1000  file /a from $.storage.files.0, line 3\n" +
+				"       400  unit a.service",
+		},
+		{
+			1000,
+			10,
+			"config is 3000 bytes, exceeding the limit of 1000 bytes by 2000 bytes\n" +
+				"largest contributors:\n" +
+				"      1500  tree tree (3 nodes) from $.storage.trees.0, line 5\n" +
+				"            exclude unneeded files, or host the largest files with --split-dir\n" +
+				"      1000  file /a from $.storage.files.0, line 3\n" +
+				"            host the contents with --split-dir; they don't shrink with gzip\n" +
+				"       400  unit a.service",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("report %d", i), func(t *testing.T) {
+			assert.Equal(t, test.out, usage.Report(test.limit, "limit", test.count), "bad report")
+		})
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	large := strings.Repeat("z", 1100*1024)
+	tests := []struct {
+		in                        string
+		limit                     string
+		machineConfig             bool
+		noResourceAutoCompression bool
+		suggestion                string
+	}{
+		// compressed contents of an Ignition config
+		{
+			"variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /large\n      contents:\n        inline: " + large,
+			"1K",
+			false,
+			false,
+			"host the contents with --split-dir",
+		},
+		// compressed contents of a MachineConfig
+		{
+			"variant: openshift\nversion: 4.10.0\nmetadata:\n  name: m\n  labels:\n    machineconfiguration.openshift.io/role: worker\nstorage:\n  files:\n    - path: /large\n      contents:\n        inline: " + large,
+			"1K",
+			true,
+			false,
+			"",
+		},
+		// MachineConfig without automatic compression
+		{
+			"variant: openshift\nversion: 4.8.0\nmetadata:\n  name: m\n  labels:\n    machineconfiguration.openshift.io/role: worker\nstorage:\n  files:\n    - path: /large\n      contents:\n        inline: " + large,
+			"openshift-mc",
+			true,
+			true,
+			"set compression: gzip, which MachineConfigs for OpenShift 4.10 and later support",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("suggest %d", i), func(t *testing.T) {
+			options := common.TranslateBytesOptions{}
+			options.Record = &common.TranslationRecord{}
+			out, _, err := config.TranslateBytes([]byte(test.in), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			usage, err := Measure(out, options.Record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limit, description, err := ParseLimit(test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, test.machineConfig, usage.MachineConfig, "bad output type")
+			assert.Equal(t, test.noResourceAutoCompression, usage.NoResourceAutoCompression, "bad auto-compression")
+			report := usage.Report(limit, description, 1)
+			assert.Contains(t, report, "exceeding the "+description, "bad report")
+			if test.suggestion == "" {
+				assert.Equal(t, 3, len(strings.Split(report, "\n")), "unexpected suggestion")
+			} else {
+				assert.Contains(t, report, "\n            "+test.suggestion, "bad suggestion")
+			}
+		})
+	}
+}
+
+func contextPath(p ...interface{}) *path.ContextPath {
+	ret := path.New("yaml", p...)
+	return &ret
+}
@@ -0,0 +1,184 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package build translates a directory tree of Butane configs.
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/config/lint"
+
+	"github.com/coreos/vcontext/report"
+)
+
+const inputExt = ".bu"
+
+var (
+	ErrWarnings         = errors.New("config produced warnings and --strict was specified")
+	ErrMultipleIgnition = errors.New("config has multiple documents producing Ignition configs")
+)
+
+type Options struct {
+	common.TranslateBytesOptions
+	Jobs   int         // number of configs to translate concurrently
+	Strict bool        // treat warnings as failures
+	Lint   lint.Config // severities of lint rules
+}
+
+// Result describes the translation of one config.
+type Result struct {
+	// Path is the path of the config relative to the source directory.
+	Path string
+	// Output is the path of the output relative to the output
+	// directory, or empty if no output was written.
+	Output string
+	Report report.Report
+	// Rules holds the ID of the lint rule that produced each entry of
+	// Report, or the empty string if none did.
+	Rules []string
+	Err   error
+}
+
+// Build translates every config with a .bu extension under srcDir and
+// writes the results to the same relative paths under outDir, with a
+// .ign extension for Ignition configs and .yaml for MachineConfigs.
+// Configs are translated concurrently.  Build returns a Result for each
+// config, sorted by path, and an error if srcDir couldn't be read.
+func Build(srcDir, outDir string, options Options) ([]Result, error) {
+	var paths []string
+	err := filepath.Walk(srcDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && filepath.Ext(p) == inputExt {
+			rel, err := filepath.Rel(srcDir, p)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	jobs := options.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]Result, len(paths))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = build(srcDir, outDir, paths[i], options)
+			}
+		}()
+	}
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results, nil
+}
+
+// build translates one config and writes the output.
+func build(srcDir, outDir, relPath string, options Options) Result {
+	result := Result{
+		Path: relPath,
+	}
+	input, err := ioutil.ReadFile(filepath.Join(srcDir, relPath))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	translateOptions := options.TranslateBytesOptions
+	// lint rules need a TranslationRecord per document
+	translateOptions.Record = &common.TranslationRecord{}
+	docs := config.TranslateDocuments(input, translateOptions)
+	var outputs [][]byte
+	ignition := false
+	warned := false
+	for _, doc := range docs {
+		// don't run rules on failed translations, but still apply
+		// suppressions
+		var output []byte
+		if doc.Err == nil {
+			output = doc.Output
+		}
+		var rules []string
+		doc.Report, rules = lint.Lint(doc.Report, lint.NewInput(output, doc.Record), options.Lint)
+		if doc.Err == nil && doc.Report.IsFatal() {
+			doc.Err = lint.ErrFailed
+		}
+		for _, entry := range doc.Report.Entries {
+			if common.EntryKind(entry) == report.Warn {
+				warned = true
+			}
+		}
+		result.Report.Merge(doc.Report)
+		result.Rules = append(result.Rules, rules...)
+		if doc.Err != nil && result.Err == nil {
+			result.Err = doc.Err
+		}
+		if json.Valid(doc.Output) {
+			ignition = true
+		}
+		outputs = append(outputs, doc.Output)
+	}
+	switch {
+	case result.Err != nil:
+		return result
+	case options.Strict && warned:
+		result.Err = ErrWarnings
+		return result
+	case ignition && len(docs) > 1:
+		result.Err = ErrMultipleIgnition
+		return result
+	}
+
+	ext := ".yaml"
+	if ignition {
+		ext = ".ign"
+	}
+	output := strings.TrimSuffix(relPath, inputExt) + ext
+	outPath := filepath.Join(outDir, output)
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		result.Err = err
+		return result
+	}
+	if err := ioutil.WriteFile(outPath, append(bytes.Join(outputs, []byte("\n---\n")), '\n'), 0644); err != nil {
+		result.Err = err
+		return result
+	}
+	result.Output = output
+	return result
+}
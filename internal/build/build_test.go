@@ -0,0 +1,109 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	srcDir := t.TempDir()
+	configs := map[string]string{
+		"a.bu":          "variant: fcos\nversion: 1.4.0\n",
+		"sub/b.bu":      "variant: fcos\nversion: 1.3.0\nq: z\n",
+		"sub/c.bu":      "variant: openshift\nversion: 4.10.0\nmetadata:\n  name: c\n  labels:\n    machineconfiguration.openshift.io/role: worker\n",
+		"sub/d/bad.bu":  "variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: z\n",
+		"sub/ignored.y": "variant: fcos\nversion: 1.4.0\n",
+	}
+	// enough configs to keep every worker busy
+	for i := 0; i < 20; i++ {
+		configs[fmt.Sprintf("many/%02d.bu", i)] = fmt.Sprintf("variant: fcos\nversion: 1.4.0\nstorage:\n  files:\n    - path: /%d\n", i)
+	}
+	for name, contents := range configs {
+		p := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		strict  bool
+		outputs map[string]string
+		failed  []string
+	}{
+		{
+			false,
+			map[string]string{
+				"a.bu":     "a.ign",
+				"sub/b.bu": "sub/b.ign",
+				"sub/c.bu": "sub/c.yaml",
+			},
+			[]string{"sub/d/bad.bu"},
+		},
+		{
+			true,
+			map[string]string{
+				"a.bu":     "a.ign",
+				"sub/c.bu": "sub/c.yaml",
+			},
+			[]string{"sub/b.bu", "sub/d/bad.bu"},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("build %d", i), func(t *testing.T) {
+			outDir := t.TempDir()
+			results, err := Build(srcDir, outDir, Options{
+				Jobs:   4,
+				Strict: test.strict,
+			})
+			if !assert.NoError(t, err, "build failed") {
+				return
+			}
+			assert.Equal(t, len(configs)-1, len(results), "bad result count")
+			outputs := make(map[string]string)
+			var failed []string
+			for j, result := range results {
+				if j > 0 {
+					assert.True(t, results[j-1].Path < result.Path, "results not sorted")
+				}
+				if result.Err != nil {
+					assert.Empty(t, result.Output, "output written for failed config")
+					failed = append(failed, filepath.ToSlash(result.Path))
+					continue
+				}
+				_, err := os.Stat(filepath.Join(outDir, result.Output))
+				assert.NoError(t, err, "missing output")
+				if filepath.Dir(result.Path) != "many" {
+					outputs[filepath.ToSlash(result.Path)] = filepath.ToSlash(result.Output)
+				}
+			}
+			assert.Equal(t, test.outputs, outputs, "bad outputs")
+			assert.Equal(t, test.failed, failed, "bad failures")
+			contents, err := ioutil.ReadFile(filepath.Join(outDir, "many", "07.ign"))
+			assert.NoError(t, err, "couldn't read output")
+			assert.Contains(t, string(contents), `"path":"/7"`, "bad output")
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package depfile writes dependency files in Make syntax.
+package depfile
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Format returns a Make rule declaring that each target depends on each
+// of the dependencies, with one dependency per line.
+func Format(targets, deps []string) []byte {
+	var buf bytes.Buffer
+	for i, target := range targets {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteString(escape(target))
+	}
+	buf.WriteString(":")
+	for _, dep := range deps {
+		buf.WriteString(" \\\n  ")
+		buf.WriteString(escape(dep))
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// escape quotes characters that are special in Make rules.
+func escape(path string) string {
+	var buf strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch c {
+		case '$':
+			buf.WriteString("$$")
+		case ' ', '\t', '#', ':':
+			// backslashes before the escaped character must be
+			// doubled too
+			for j := i - 1; j >= 0 && path[j] == '\\'; j-- {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package depfile
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		targets []string
+		deps    []string
+		out     string
+	}{
+		{
+			[]string{"out.ign"},
+			nil,
+			"out.ign:\n",
+		},
+		{
+			[]string{"out/a.ign", "out/b.ign"},
+			[]string{"in.bu", "files/tree", "files/tree/a"},
+			"out/a.ign out/b.ign: \\\n  in.bu \\\n  files/tree \\\n  files/tree/a\n",
+		},
+		// special characters
+		{
+			[]string{"my out.ign"},
+			[]string{"a$b", "c#d", `e\ f`, "g:h"},
+			"my\\ out.ign: \\\n  a$$b \\\n  c\\#d \\\n  e\\\\\\ f \\\n  g\\:h\n",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("format %d", i), func(t *testing.T) {
+			assert.Equal(t, test.out, string(Format(test.targets, test.deps)), "bad depfile")
+		})
+	}
+}
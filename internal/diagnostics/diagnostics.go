@@ -0,0 +1,179 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package diagnostics writes translation reports for the command line,
+// optionally identifying each entry by its stable code.
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/report"
+)
+
+// Report formats
+const (
+	FormatText = "text" // one line per entry, as printed by report.Report
+	FormatJSON = "json" // a single JSON Report after all entries
+)
+
+// Formats returns the supported report formats.
+func Formats() []string {
+	return []string{FormatText, FormatJSON}
+}
+
+type Report struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is a report entry in JSON format.
+type Entry struct {
+	// File is the config that produced the entry, if the report
+	// covers more than one.
+	File string `json:"file,omitempty"`
+	// Document is the number of the YAML document that produced the
+	// entry, if the input has more than one.
+	Document int `json:"document,omitempty"`
+	// Kind is "error", "warning", or "info".
+	Kind string `json:"kind"`
+	Code string `json:"code"`
+	// Rule is the ID of the lint rule that produced the entry, if
+	// any.
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+	// Path is the path of the field in the config, such as
+	// "$.storage.files.0.mode".
+	Path   string `json:"path,omitempty"`
+	Line   int64  `json:"line,omitempty"`
+	Column int64  `json:"column,omitempty"`
+}
+
+// NewEntry returns the JSON form of a report entry produced by the
+// specified lint rule, if any.
+func NewEntry(e report.Entry, rule string) Entry {
+	ret := Entry{
+		Kind:    e.Kind.String(),
+		Code:    common.EntryCode(e),
+		Rule:    rule,
+		Message: e.Message,
+	}
+	if e.Context.Len() != 0 {
+		ret.Path = e.Context.String()
+	}
+	if e.Marker.StartP != nil {
+		ret.Line = e.Marker.StartP.Line
+		ret.Column = e.Marker.StartP.Column
+	}
+	return ret
+}
+
+// Tag returns a copy of the report with the code of each entry prepended
+// to its message.
+func Tag(r report.Report) report.Report {
+	ret := report.Report{
+		Entries: make([]report.Entry, 0, len(r.Entries)),
+	}
+	for _, e := range r.Entries {
+		e.Message = common.EntryCode(e) + ": " + e.Message
+		ret.Entries = append(ret.Entries, e)
+	}
+	return ret
+}
+
+// Printer writes reports in one of the report formats.  Text reports are
+// written as they're printed; JSON reports are collected and written by
+// Flush.
+type Printer struct {
+	// Codes prepends the code of each entry to its message in text
+	// reports.  JSON reports always include codes.
+	Codes bool
+	// Rules appends the ID of the lint rule that produced each entry,
+	// if any, in text reports.  JSON reports always include rule IDs.
+	Rules bool
+
+	w      io.Writer
+	format string
+	report Report
+}
+
+// NewPrinter returns a Printer that writes reports in the specified
+// format to w.
+func NewPrinter(w io.Writer, format string) (*Printer, error) {
+	switch format {
+	case FormatText, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+	return &Printer{
+		w:      w,
+		format: format,
+		report: Report{
+			Entries: []Entry{},
+		},
+	}, nil
+}
+
+// Print prints a report from the specified file or document, either of
+// which may be omitted.  rules holds the ID of the lint rule that
+// produced each entry, as returned by lint.Lint, or is nil if the report
+// wasn't linted.
+func (p *Printer) Print(file string, document int, r report.Report, rules []string) {
+	rule := func(i int) string {
+		if i < len(rules) {
+			return rules[i]
+		}
+		return ""
+	}
+	if p.format == FormatJSON {
+		for i, e := range r.Entries {
+			entry := NewEntry(e, rule(i))
+			entry.File = file
+			entry.Document = document
+			p.report.Entries = append(p.report.Entries, entry)
+		}
+		return
+	}
+	if p.Codes {
+		r = Tag(r)
+	}
+	for i, e := range r.Entries {
+		line := e.String()
+		if id := rule(i); p.Rules && id != "" {
+			line += " [" + id + "]"
+		}
+		if file != "" {
+			fmt.Fprintf(p.w, "%s: %s\n", file, line)
+		} else {
+			fmt.Fprintf(p.w, "%s\n", line)
+		}
+	}
+}
+
+// Flush writes the collected JSON report, if any, and starts a new one.
+func (p *Printer) Flush() error {
+	if p.format != FormatJSON {
+		return nil
+	}
+	data, err := json.Marshal(p.report)
+	if err != nil {
+		return err
+	}
+	p.report.Entries = []Entry{}
+	_, err = fmt.Fprintf(p.w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package diagnostics
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config/common"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/coreos/vcontext/report"
+	"github.com/coreos/vcontext/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrinter(t *testing.T) {
+	r := report.Report{
+		Entries: []report.Entry{
+			{
+				Kind:    report.Warn,
+				Message: common.ErrDecimalMode.Error(),
+				Context: path.New("yaml", "storage", "files", 0, "mode"),
+				Marker: tree.Marker{
+					StartP: &tree.Pos{Line: 7, Column: 13},
+				},
+			},
+			{
+				Kind:    report.Error,
+				Message: "yaml: did not find expected key",
+			},
+			{
+				Kind:    report.Info,
+				Message: "Unused key foo",
+				Context: path.New("yaml", "foo"),
+			},
+			{
+				// code attached when the entry was created
+				Kind: common.CodedKind{
+					Kind: report.Warn,
+					Code: "BU1044",
+				},
+				Message: "reworded message",
+			},
+		},
+	}
+
+	rules := []string{"decimal-mode", "", "unused-key"}
+
+	tests := []struct {
+		format   string
+		codes    bool
+		rules    bool
+		file     string
+		document int
+		out      string
+	}{
+		{
+			FormatText,
+			false,
+			false,
+			"",
+			0,
+			"warning at $.storage.files.0.mode, line 7 col 13: " + common.ErrDecimalMode.Error() + "\n" +
+				"error: yaml: did not find expected key\n" +
+				"info at $.foo: Unused key foo\n" +
+				"warning: reworded message\n",
+		},
+		{
+			FormatText,
+			true,
+			true,
+			"",
+			0,
+			"warning at $.storage.files.0.mode, line 7 col 13: BU1037: " + common.ErrDecimalMode.Error() + " [decimal-mode]\n" +
+				"error: BU0000: yaml: did not find expected key\n" +
+				"info at $.foo: BU3001: Unused key foo [unused-key]\n" +
+				"warning: BU1044: reworded message\n",
+		},
+		{
+			FormatText,
+			true,
+			false,
+			"dir/a.bu",
+			2,
+			"dir/a.bu: warning at $.storage.files.0.mode, line 7 col 13: BU1037: " + common.ErrDecimalMode.Error() + "\n" +
+				"dir/a.bu: error: BU0000: yaml: did not find expected key\n" +
+				"dir/a.bu: info at $.foo: BU3001: Unused key foo\n" +
+				"dir/a.bu: warning: BU1044: reworded message\n",
+		},
+		{
+			FormatJSON,
+			false,
+			false,
+			"",
+			0,
+			`{"entries":[` +
+				`{"kind":"warning","code":"BU1037","rule":"decimal-mode","message":"` + common.ErrDecimalMode.Error() + `","path":"$.storage.files.0.mode","line":7,"column":13},` +
+				`{"kind":"error","code":"BU0000","message":"yaml: did not find expected key"},` +
+				`{"kind":"info","code":"BU3001","rule":"unused-key","message":"Unused key foo","path":"$.foo"},` +
+				`{"kind":"warning","code":"BU1044","message":"reworded message"}` +
+				"]}\n",
+		},
+		{
+			FormatJSON,
+			false,
+			false,
+			"dir/a.bu",
+			2,
+			`{"entries":[` +
+				`{"file":"dir/a.bu","document":2,"kind":"warning","code":"BU1037","rule":"decimal-mode","message":"` + common.ErrDecimalMode.Error() + `","path":"$.storage.files.0.mode","line":7,"column":13},` +
+				`{"file":"dir/a.bu","document":2,"kind":"error","code":"BU0000","message":"yaml: did not find expected key"},` +
+				`{"file":"dir/a.bu","document":2,"kind":"info","code":"BU3001","rule":"unused-key","message":"Unused key foo","path":"$.foo"},` +
+				`{"file":"dir/a.bu","document":2,"kind":"warning","code":"BU1044","message":"reworded message"}` +
+				"]}\n",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("printer %d", i), func(t *testing.T) {
+			var buf bytes.Buffer
+			printer, err := NewPrinter(&buf, test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			printer.Codes = test.codes
+			printer.Rules = test.rules
+			printer.Print(test.file, test.document, r, rules)
+			if err := printer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, test.out, buf.String())
+			// Flush starts a new report
+			buf.Reset()
+			if err := printer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if test.format == FormatJSON {
+				assert.Equal(t, "{\"entries\":[]}\n", buf.String())
+			} else {
+				assert.Empty(t, buf.String())
+			}
+		})
+	}
+
+	_, err := NewPrinter(&bytes.Buffer{}, "xml")
+	assert.Error(t, err)
+}
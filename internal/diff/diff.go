@@ -0,0 +1,502 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package diff compares two translated configs node by node.
+package diff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/internal/outputdoc"
+
+	"github.com/coreos/vcontext/path"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Changed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "+"
+	case Removed:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+// Config is one side of a comparison: the output of a translation and the
+// record of how it was produced.
+type Config struct {
+	Output []byte
+	Record *common.TranslationRecord
+}
+
+// Change describes an added, removed, or changed node.
+type Change struct {
+	Kind ChangeKind
+	// Node names the node, e.g. "file /etc/hosts"
+	Node string
+	// OldSource and NewSource describe the Butane config field that
+	// produced the node in each config, if known
+	OldSource string
+	NewSource string
+	// Details describes the changes within a changed node
+	Details []string
+}
+
+func (c Change) String() string {
+	var sources []string
+	if c.OldSource != "" {
+		sources = append(sources, "old: "+c.OldSource)
+	}
+	if c.NewSource != "" {
+		sources = append(sources, "new: "+c.NewSource)
+	}
+	str := fmt.Sprintf("%s %s", c.Kind, c.Node)
+	if len(sources) > 0 {
+		str += fmt.Sprintf(" (%s)", strings.Join(sources, "; "))
+	}
+	for _, detail := range c.Details {
+		for _, line := range strings.Split(strings.TrimRight(detail, "\n"), "\n") {
+			str += "\n    " + line
+		}
+	}
+	return str
+}
+
+// section is a list of Ignition config nodes identified by a key field,
+// as for Ignition config merging.
+type section struct {
+	path  []string
+	key   string
+	label string
+}
+
+var sections = []section{
+	{[]string{"storage", "directories"}, "path", "directory"},
+	{[]string{"storage", "disks"}, "device", "disk"},
+	{[]string{"storage", "files"}, "path", "file"},
+	{[]string{"storage", "filesystems"}, "device", "filesystem"},
+	{[]string{"storage", "links"}, "path", "link"},
+	{[]string{"storage", "luks"}, "name", "luks"},
+	{[]string{"storage", "raid"}, "name", "raid"},
+	{[]string{"systemd", "units"}, "name", "unit"},
+	{[]string{"passwd", "groups"}, "name", "group"},
+	{[]string{"passwd", "users"}, "name", "user"},
+}
+
+// side is a parsed Config.
+type side struct {
+	doc    map[string]interface{}
+	record *common.TranslationRecord
+	// path to the Ignition config within doc
+	ignPath []interface{}
+}
+
+// Diff compares the Ignition configs in two translated configs.  Files,
+// directories, and links are matched by path, and units, users, and groups
+// by name; other nodes are matched by their Ignition merge keys.  File
+// contents are decoded before comparison.
+func Diff(oldConfig, newConfig Config) ([]Change, error) {
+	o, err := parse(oldConfig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old config: %w", err)
+	}
+	n, err := parse(newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new config: %w", err)
+	}
+
+	var changes []Change
+	for _, sec := range sections {
+		changes = append(changes, diffSection(sec, o, n)...)
+	}
+
+	// compare everything else field by field
+	oldLeaves := make(map[string]leaf)
+	newLeaves := make(map[string]leaf)
+	flatten(o.doc, nil, oldLeaves)
+	flatten(n.doc, nil, newLeaves)
+	var keys []string
+	for k := range oldLeaves {
+		keys = append(keys, k)
+	}
+	for k := range newLeaves {
+		if _, ok := oldLeaves[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ol, oldOk := oldLeaves[k]
+		nl, newOk := newLeaves[k]
+		change := Change{
+			Node: "field " + k,
+		}
+		switch {
+		case !oldOk:
+			change.Kind = Added
+			change.NewSource = n.source(nl.path)
+			change.Details = []string{"value: " + format(nl.value)}
+		case !newOk:
+			change.Kind = Removed
+			change.OldSource = o.source(ol.path)
+			change.Details = []string{"value: " + format(ol.value)}
+		default:
+			if reflect.DeepEqual(ol.value, nl.value) {
+				continue
+			}
+			change.Kind = Changed
+			change.OldSource = o.source(ol.path)
+			change.NewSource = n.source(nl.path)
+			change.Details = compareValues("value", ol.value, nl.value)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func parse(c Config) (side, error) {
+	doc, err := outputdoc.Parse(c.Output)
+	if err != nil {
+		return side{}, err
+	}
+	s := side{
+		doc:    doc,
+		record: c.Record,
+	}
+	_, s.ignPath = outputdoc.Ignition(doc)
+	return s, nil
+}
+
+// source describes the Butane config field that produced the specified
+// output path.
+func (s side) source(p []interface{}) string {
+	if s.record == nil {
+		return ""
+	}
+	from, line, ok := s.record.SourceOf(path.New("json", p...))
+	if !ok {
+		return ""
+	}
+	if line > 0 {
+		return fmt.Sprintf("%s, line %d", from, line)
+	}
+	return from.String()
+}
+
+// takeList removes and returns the list at the specified path relative
+// to the Ignition config, or nil if there isn't one.
+func (s side) takeList(p []string) []interface{} {
+	m := s.doc
+	for _, e := range s.ignPath {
+		next, ok := m[e.(string)].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		m = next
+	}
+	for _, e := range p[:len(p)-1] {
+		next, ok := m[e].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		m = next
+	}
+	list, _ := m[p[len(p)-1]].([]interface{})
+	delete(m, p[len(p)-1])
+	return list
+}
+
+func diffSection(sec section, o, n side) []Change {
+	oldList := o.takeList(sec.path)
+	newList := n.takeList(sec.path)
+	index := func(list []interface{}) (map[string]int, []string) {
+		m := make(map[string]int, len(list))
+		var order []string
+		for i, entry := range list {
+			key := format(entry.(map[string]interface{})[sec.key])
+			if _, ok := m[key]; !ok {
+				order = append(order, key)
+			}
+			m[key] = i
+		}
+		return m, order
+	}
+	oldIndex, oldOrder := index(oldList)
+	newIndex, newOrder := index(newList)
+	entryPath := func(s side, i int) []interface{} {
+		ret := append([]interface{}{}, s.ignPath...)
+		for _, e := range sec.path {
+			ret = append(ret, e)
+		}
+		return append(ret, i)
+	}
+
+	var changes []Change
+	for _, key := range oldOrder {
+		oi := oldIndex[key]
+		ni, ok := newIndex[key]
+		if !ok {
+			changes = append(changes, Change{
+				Kind:      Removed,
+				Node:      fmt.Sprintf("%s %s", sec.label, unquote(key)),
+				OldSource: o.source(entryPath(o, oi)),
+			})
+			continue
+		}
+		details := compareValues("", oldList[oi], newList[ni])
+		if len(details) == 0 {
+			continue
+		}
+		changes = append(changes, Change{
+			Kind:      Changed,
+			Node:      fmt.Sprintf("%s %s", sec.label, unquote(key)),
+			OldSource: o.source(entryPath(o, oi)),
+			NewSource: n.source(entryPath(n, ni)),
+			Details:   details,
+		})
+	}
+	for _, key := range newOrder {
+		if _, ok := oldIndex[key]; ok {
+			continue
+		}
+		changes = append(changes, Change{
+			Kind:      Added,
+			Node:      fmt.Sprintf("%s %s", sec.label, unquote(key)),
+			NewSource: n.source(entryPath(n, newIndex[key])),
+		})
+	}
+	return changes
+}
+
+// compareValues describes the differences between two values.  prefix
+// names the values being compared.
+func compareValues(prefix string, a, b interface{}) []string {
+	am, aIsMap := a.(map[string]interface{})
+	bm, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		return compareMaps(prefix, am, bm)
+	}
+	al, aIsList := a.([]interface{})
+	bl, bIsList := b.([]interface{})
+	if aIsList && bIsList {
+		var ret []string
+		for i := 0; i < len(al) || i < len(bl); i++ {
+			name := join(prefix, fmt.Sprintf("%d", i))
+			switch {
+			case i >= len(al):
+				ret = append(ret, fmt.Sprintf("%s: added %s", name, format(bl[i])))
+			case i >= len(bl):
+				ret = append(ret, fmt.Sprintf("%s: removed %s", name, format(al[i])))
+			default:
+				ret = append(ret, compareValues(name, al[i], bl[i])...)
+			}
+		}
+		return ret
+	}
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	as, aIsString := a.(string)
+	bs, bIsString := b.(string)
+	if aIsString && bIsString && (strings.Contains(as, "\n") || strings.Contains(bs, "\n")) {
+		return []string{prefix + ":\n" + textDiff([]byte(as), []byte(bs))}
+	}
+	if strings.HasSuffix(prefix, "mode") {
+		return []string{fmt.Sprintf("%s: %s → %s", prefix, formatMode(a), formatMode(b))}
+	}
+	return []string{fmt.Sprintf("%s: %s → %s", prefix, format(a), format(b))}
+}
+
+func compareMaps(prefix string, a, b map[string]interface{}) []string {
+	var ret []string
+	skip := make(map[string]struct{})
+	// Compare decoded resource contents rather than data URLs, and
+	// ignore changes in the compression used to encode them.
+	aContents, aOk := decodeResource(a)
+	bContents, bOk := decodeResource(b)
+	if aOk && bOk {
+		skip["source"] = struct{}{}
+		skip["compression"] = struct{}{}
+		if !bytes.Equal(aContents, bContents) {
+			name := prefix
+			if name == "" {
+				name = "contents"
+			}
+			ret = append(ret, name+":\n"+textDiff(aContents, bContents))
+		}
+	}
+
+	keys := make(map[string]struct{})
+	for k := range a {
+		keys[k] = struct{}{}
+	}
+	for k := range b {
+		keys[k] = struct{}{}
+	}
+	var sorted []string
+	for k := range keys {
+		if _, ok := skip[k]; !ok {
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		av, aHas := a[k]
+		bv, bHas := b[k]
+		name := join(prefix, k)
+		switch {
+		case !aHas:
+			ret = append(ret, fmt.Sprintf("%s: added %s", name, format(bv)))
+		case !bHas:
+			ret = append(ret, fmt.Sprintf("%s: removed %s", name, format(av)))
+		default:
+			ret = append(ret, compareValues(name, av, bv)...)
+		}
+	}
+	return ret
+}
+
+// decodeResource returns the decoded contents of an Ignition resource
+// with a data URL source.
+func decodeResource(m map[string]interface{}) ([]byte, bool) {
+	source, ok := m["source"].(string)
+	if !ok || !strings.HasPrefix(source, "data:") {
+		return nil, false
+	}
+	var compression *string
+	if c, ok := m["compression"].(string); ok {
+		compression = &c
+	}
+	contents, err := baseutil.DecodeDataURL(source, compression)
+	if err != nil {
+		return nil, false
+	}
+	return contents, true
+}
+
+// textDiff returns a unified diff of a and b, or a summary if either is
+// binary.
+func textDiff(a, b []byte) string {
+	if !utf8.Valid(a) || !utf8.Valid(b) || bytes.IndexByte(a, 0) != -1 || bytes.IndexByte(b, 0) != -1 {
+		return fmt.Sprintf("binary contents differ (%d bytes, sha256 %s → %d bytes, sha256 %s)", len(a), shortHash(a), len(b), shortHash(b))
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(a)),
+		B:        splitLines(string(b)),
+		FromFile: "old",
+		ToFile:   "new",
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Sprintf("contents differ: %v", err)
+	}
+	return strings.TrimRight(diff, "\n")
+}
+
+// splitLines splits s into newline-terminated lines.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+func shortHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// leaf is a scalar value and its path in the document.
+type leaf struct {
+	path  []interface{}
+	value interface{}
+}
+
+// flatten records the leaf values of v, keyed by dotted path.
+func flatten(v interface{}, prefix []interface{}, out map[string]leaf) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			flatten(child, append(append([]interface{}{}, prefix...), k), out)
+		}
+	case []interface{}:
+		for i, child := range t {
+			flatten(child, append(append([]interface{}{}, prefix...), i), out)
+		}
+	default:
+		var names []string
+		for _, e := range prefix {
+			names = append(names, fmt.Sprintf("%v", e))
+		}
+		out[strings.Join(names, ".")] = leaf{
+			path:  prefix,
+			value: v,
+		}
+	}
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func format(v interface{}) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(encoded)
+}
+
+// formatMode formats a file mode in octal.
+func formatMode(v interface{}) string {
+	if n, ok := v.(json.Number); ok {
+		if mode, err := n.Int64(); err == nil {
+			return fmt.Sprintf("%04o", mode)
+		}
+	}
+	return format(v)
+}
+
+func unquote(s string) string {
+	var ret string
+	if err := json.Unmarshal([]byte(s), &ret); err != nil {
+		return s
+	}
+	return ret
+}
@@ -0,0 +1,155 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package diff
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func translate(t *testing.T, in string) Config {
+	options := common.TranslateBytesOptions{}
+	options.Record = &common.TranslationRecord{}
+	out, _, err := config.TranslateBytes([]byte(in), options)
+	if err != nil {
+		t.Fatalf("translation failed: %v", err)
+	}
+	return Config{
+		Output: out,
+		Record: options.Record,
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		old string
+		new string
+		out []string
+	}{
+		// identical
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /z
+      contents:
+        inline: z`,
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /z
+      contents:
+        inline: z`,
+			nil,
+		},
+		// reordered, and recompressed contents
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /y
+    - path: /z
+      contents:
+        inline: zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz`,
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /z
+      contents:
+        compression: gzip
+        source: data:;base64,H4sIAAAAAAACA6uqog4AACVGzTlIAAAA
+    - path: /y`,
+			nil,
+		},
+		// changes
+		{
+			`variant: fcos
+version: 1.4.0
+ignition:
+  timeouts:
+    http_total: 10
+storage:
+  files:
+    - path: /y
+    - path: /z
+      mode: 0644
+      contents:
+        inline: |
+          a
+          b
+  filesystems:
+    - device: /dev/vda
+      path: /var
+      format: xfs
+      with_mount_unit: true
+passwd:
+  users:
+    - name: core`,
+			`variant: fcos
+version: 1.4.0
+ignition:
+  timeouts:
+    http_total: 20
+storage:
+  files:
+    - path: /z
+      mode: 0600
+      contents:
+        inline: |
+          a
+          c
+  filesystems:
+    - device: /dev/vda
+      path: /var
+      format: ext4
+      with_mount_unit: true
+passwd:
+  users:
+    - name: core
+    - name: z`,
+			[]string{
+				"- file /y (old: $.storage.files.0, line 8)",
+				"~ file /z (old: $.storage.files.1, line 9; new: $.storage.files.0, line 8)\n    contents:\n    --- old\n    +++ new\n    @@ -1,2 +1,2 @@\n     a\n    -b\n    +c\n    mode: 0644 → 0600",
+				"~ filesystem /dev/vda (old: $.storage.filesystems.0, line 16; new: $.storage.filesystems.0, line 15)\n    format: \"xfs\" → \"ext4\"",
+				"~ unit var.mount (old: $.storage.filesystems.0.with_mount_unit, line 19; new: $.storage.filesystems.0.with_mount_unit, line 18)\n    contents:\n    --- old\n    +++ new\n    @@ -7,7 +7,7 @@\n     [Mount]\n     Where=/var\n     What=/dev/vda\n    -Type=xfs\n    +Type=ext4\n     \n     [Install]\n     RequiredBy=local-fs.target",
+				"+ user z (new: $.passwd.users.1, line 22)",
+				"~ field ignition.timeouts.httpTotal (old: $.ignition.timeouts.http_total, line 5; new: $.ignition.timeouts.http_total, line 5)\n    value: 10 → 20",
+			},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("diff %d", i), func(t *testing.T) {
+			changes, err := Diff(translate(t, test.old), translate(t, test.new))
+			if !assert.NoError(t, err, "diff failed") {
+				return
+			}
+			var out []string
+			for _, change := range changes {
+				out = append(out, change.String())
+			}
+			assert.Equal(t, test.out, out, "bad diff")
+		})
+	}
+}
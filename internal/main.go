@@ -15,29 +15,84 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/coreos/vcontext/report"
 	"github.com/spf13/pflag"
 
 	"github.com/coreos/butane/config"
 	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/config/lint"
+	"github.com/coreos/butane/config/merge"
+	"github.com/coreos/butane/config/wrap"
+	"github.com/coreos/butane/internal/annotate"
+	"github.com/coreos/butane/internal/budget"
+	"github.com/coreos/butane/internal/build"
+	"github.com/coreos/butane/internal/depfile"
+	"github.com/coreos/butane/internal/diagnostics"
+	"github.com/coreos/butane/internal/diff"
+	"github.com/coreos/butane/internal/manifest"
+	"github.com/coreos/butane/internal/split"
 	"github.com/coreos/butane/internal/version"
+	"github.com/coreos/butane/internal/watch"
 )
 
+// how often --watch checks for changes
+const watchInterval = 500 * time.Millisecond
+
+// number of contributors listed in size reports
+const sizeReportCount = 10
+
 func fail(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, args...)
 	os.Exit(1)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			diffMain(os.Args[2:])
+			return
+		case "merge":
+			mergeMain(os.Args[2:])
+			return
+		case "build":
+			buildMain(os.Args[2:])
+			return
+		}
+	}
+
 	var (
-		input       string
-		output      string
-		strict      bool
-		helpFlag    bool
-		versionFlag bool
+		input        string
+		output       string
+		outputDir    string
+		depfilePath  string
+		manifestPath string
+		splitOpts    split.Options
+		wrapPlatform string
+		wrapOpts     wrap.Options
+		maxSize      string
+		warnSize     string
+		sizeReport   bool
+		lintPath     string
+		failOn       []string
+		strict       bool
+		reportFormat string
+		showCodes    bool
+		minVersion   bool
+		annotated    bool
+		watchFlag    bool
+		helpFlag     bool
+		versionFlag  bool
 	)
 	options := common.TranslateBytesOptions{}
 	pflag.BoolVarP(&helpFlag, "help", "h", false, "show usage and exit")
@@ -45,16 +100,38 @@ func main() {
 	pflag.BoolVarP(&options.DebugPrintTranslations, "debug", "D", false, "log translations")
 	pflag.Lookup("debug").Hidden = true
 	pflag.BoolVarP(&strict, "strict", "s", false, "fail on any warning")
+	pflag.StringVar(&lintPath, "lint-config", "", fmt.Sprintf("read lint rule settings from this file instead of the nearest %s", lint.ConfigFileName))
+	pflag.StringSliceVar(&failOn, "fail-on", nil, "fail if any of these comma-separated lint rules are triggered")
+	pflag.StringVar(&reportFormat, "report-format", diagnostics.FormatText, fmt.Sprintf("format of warnings and errors (%s)", strings.Join(diagnostics.Formats(), ", ")))
+	pflag.BoolVar(&showCodes, "show-codes", false, "prefix text warnings and errors with their error codes")
 	pflag.BoolVarP(&options.Pretty, "pretty", "p", false, "output formatted json")
 	pflag.BoolVarP(&options.Raw, "raw", "r", false, "never wrap in a MachineConfig; force Ignition output")
+	pflag.BoolVar(&annotated, "annotate", false, "output formatted config with comments naming the source of each field")
+	pflag.BoolVar(&minVersion, "min-version", false, "report the minimum spec version that can translate the config")
+	pflag.BoolVar(&watchFlag, "watch", false, "translate again whenever the input file or a local file it uses changes")
 	pflag.StringVar(&input, "input", "", "read from input file instead of stdin")
 	pflag.Lookup("input").Deprecated = "specify filename directly on command line"
 	pflag.Lookup("input").Hidden = true
 	pflag.StringVarP(&output, "output", "o", "", "write to output file instead of stdout")
-	pflag.StringVarP(&options.FilesDir, "files-dir", "d", "", "allow embedding local files from this directory")
+	pflag.StringVar(&outputDir, "output-dir", "", "write each document of a multi-document input to a file in this directory")
+	pflag.StringVar(&depfilePath, "depfile", "", "write a Make dependency file listing the local files read")
+	pflag.StringVar(&manifestPath, "manifest", "", "write a JSON manifest of the files, directories, links, and units in the output")
+	pflag.StringVar(&splitOpts.BaseURL, "split-base-url", "", "URL at which the --split-dir directory will be hosted")
+	pflag.StringVar(&splitOpts.Dir, "split-dir", "", "write large file contents to this directory for hosting at --split-base-url")
+	pflag.IntVar(&splitOpts.Threshold, "split-threshold", split.DefaultThreshold, "move file contents whose data URLs are longer than this many bytes")
+	pflag.BoolVar(&splitOpts.Pointer, "split-pointer", false, "also host the config, and output a config pointing to it")
+	pflag.StringVar(&wrapPlatform, "wrap", "", fmt.Sprintf("wrap the Ignition output for delivery to a platform (%s)", strings.Join(wrap.Platforms(), ", ")))
+	pflag.StringVar(&wrapOpts.Name, "wrap-name", "", "name of the object created by --wrap, if any")
+	pflag.StringVar(&maxSize, "max-size", "", fmt.Sprintf("fail if the output exceeds this many bytes or a preset limit (%s)", strings.Join(budget.PresetNames(), ", ")))
+	pflag.StringVar(&warnSize, "warn-size", "", "warn if the output exceeds this many bytes or a preset limit")
+	pflag.BoolVar(&sizeReport, "size-report", false, "report the size of the output and its largest contributors")
+	addTranslateFlags(pflag.CommandLine, &options.TranslateOptions)
 
 	pflag.Usage = func() {
 		fmt.Fprintf(pflag.CommandLine.Output(), "Usage: %s [options] [input-file]\n", os.Args[0])
+		fmt.Fprintf(pflag.CommandLine.Output(), "       %s diff [options] old-file new-file\n", os.Args[0])
+		fmt.Fprintf(pflag.CommandLine.Output(), "       %s merge [options] base-file overlay-file...\n", os.Args[0])
+		fmt.Fprintf(pflag.CommandLine.Output(), "       %s build [options] -o output-dir source-dir\n", os.Args[0])
 		fmt.Fprintf(pflag.CommandLine.Output(), "Options:\n")
 		pflag.PrintDefaults()
 	}
@@ -79,8 +156,87 @@ func main() {
 		os.Exit(0)
 	}
 
+	if output != "" && outputDir != "" {
+		fail("--output and --output-dir are mutually exclusive\n")
+	}
+	if watchFlag && input == "" {
+		fail("--watch requires an input file\n")
+	}
+	if depfilePath != "" && output == "" && outputDir == "" {
+		fail("--depfile requires --output or --output-dir\n")
+	}
+	if (splitOpts.BaseURL == "") != (splitOpts.Dir == "") {
+		fail("--split-base-url and --split-dir must be specified together\n")
+	}
+	if splitOpts.Dir != "" && annotated {
+		fail("--split-dir and --annotate are mutually exclusive\n")
+	}
+	splitOpts.Pretty = options.Pretty
+	if wrapPlatform != "" && (annotated || minVersion) {
+		fail("--wrap is incompatible with --annotate and --min-version\n")
+	}
+	if wrapPlatform != "" && !contains(wrap.Platforms(), wrapPlatform) {
+		fail("--wrap: unknown platform %s; valid platforms: %s\n", wrapPlatform, strings.Join(wrap.Platforms(), ", "))
+	}
+
+	if _, err := diagnostics.NewPrinter(os.Stderr, reportFormat); err != nil {
+		fail("--report-format: %v\n", err)
+	}
+	if reportFormat != diagnostics.FormatText && minVersion {
+		fail("--report-format is incompatible with --min-version\n")
+	}
+
+	if (maxSize != "" || warnSize != "" || sizeReport) && minVersion {
+		fail("--max-size, --warn-size, and --size-report are incompatible with --min-version\n")
+	}
+	var maxLimit, warnLimit sizeLimit
+	if maxSize != "" {
+		var err error
+		if maxLimit.bytes, maxLimit.description, err = budget.ParseLimit(maxSize); err != nil {
+			fail("--max-size: %v\n", err)
+		}
+	}
+	if warnSize != "" {
+		var err error
+		if warnLimit.bytes, warnLimit.description, err = budget.ParseLimit(warnSize); err != nil {
+			fail("--warn-size: %v\n", err)
+		}
+	}
+
+	lintDir := "."
+	if input != "" {
+		lintDir = filepath.Dir(input)
+	}
+	lintConfig, err := loadLintConfig(lintPath, lintDir, failOn)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	opts := translateOptions{
+		TranslateBytesOptions: options,
+		input:                 input,
+		output:                output,
+		outputDir:             outputDir,
+		depfile:               depfilePath,
+		manifest:              manifestPath,
+		split:                 splitOpts,
+		wrapPlatform:          wrapPlatform,
+		wrap:                  wrapOpts,
+		maxSize:               maxLimit,
+		warnSize:              warnLimit,
+		sizeReport:            sizeReport,
+		lint:                  lintConfig,
+		strict:                strict,
+		reportFormat:          reportFormat,
+		showCodes:             showCodes,
+		minVersion:            minVersion,
+		annotated:             annotated,
+	}
+	if watchFlag {
+		watchInput(input, opts)
+	}
+
 	infile := os.Stdin
-	outfile := os.Stdout
 	if input != "" {
 		var err error
 		infile, err = os.Open(input)
@@ -95,25 +251,717 @@ func main() {
 		fail("failed to read %s: %v\n", infile.Name(), err)
 	}
 
-	dataOut, r, err := config.TranslateBytes(dataIn, options)
-	fmt.Fprintf(os.Stderr, "%s", r.String())
+	if _, err := translateInput(dataIn, opts); err != nil {
+		fail("%v\n", err)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// addTranslateFlags registers the flags for the translation options
+// shared by all commands.
+func addTranslateFlags(flags *pflag.FlagSet, options *common.TranslateOptions) {
+	flags.StringVarP(&options.FilesDir, "files-dir", "d", "", "allow embedding local files from this directory or archive")
+	flags.BoolVar(&options.FollowSymlinks, "follow-symlinks", false, "allow symlinks in the files directory to point outside it")
+	flags.BoolVar(&options.EmbedRemote, "embed-remote", false, "download http(s) resources and embed them in the output")
+	flags.BoolVar(&options.PinRemote, "pin-remote", false, "download http(s) resources and add their sha512 hashes to the output")
+	flags.StringVar(&options.RemoteCacheDir, "remote-cache", "", "cache downloaded resources in this directory")
+	flags.DurationVar(&options.RemoteTimeout, "remote-timeout", common.DefaultRemoteTimeout, "time allowed for downloading each remote resource")
+	flags.BoolVar(&options.ScanSecrets, "scan-secrets", false, "warn about embedded contents that appear to contain secrets")
+	flags.StringArrayVar(&options.SecretAllowlist, "allow-secret", nil, "skip secret scanning of files matching this path or pattern (repeatable)")
+}
+
+// translateOptions are the settings for translating an input file.
+type translateOptions struct {
+	common.TranslateBytesOptions
+	input        string
+	output       string
+	outputDir    string
+	depfile      string
+	manifest     string
+	split        split.Options
+	wrapPlatform string
+	wrap         wrap.Options
+	maxSize      sizeLimit
+	warnSize     sizeLimit
+	sizeReport   bool
+	lint         lint.Config
+	strict       bool
+	reportFormat string
+	showCodes    bool
+	minVersion   bool
+	annotated    bool
+}
+
+// sizeLimit is a parsed --max-size or --warn-size argument.
+type sizeLimit struct {
+	bytes       int
+	description string
+}
+
+// watchInput translates the input file whenever it or any local file
+// read during translation changes.  It never returns.
+func watchInput(input string, opts translateOptions) {
+	for {
+		files := []string{input}
+		if dataIn, err := ioutil.ReadFile(input); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", input, err)
+		} else {
+			read, err := translateInput(dataIn, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+			files = append(files, read...)
+		}
+		fmt.Fprintf(os.Stderr, "Watching %d files for changes...\n", len(files))
+		watch.Take(files).Wait(watchInterval)
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+}
+
+// translateInput translates the input and writes the output.  Reports are
+// printed to stderr.  It returns the local files read during translation.
+func translateInput(dataIn []byte, opts translateOptions) ([]string, error) {
+	var dataOut []byte
+	var files []string
+	if opts.minVersion {
+		var err error
+		dataOut, err = minimumVersion(dataIn, opts.TranslateBytesOptions, opts.showCodes)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		options := opts.TranslateBytesOptions
+		// also needed for finding the files that were read
+		options.Record = &common.TranslationRecord{}
+		docs, err := translateDocuments(dataIn, options, opts)
+		for _, doc := range docs {
+			if doc.Record != nil {
+				files = append(files, doc.Record.Files...)
+			}
+		}
+		if err != nil {
+			return files, err
+		}
+		if opts.outputDir == "" && len(docs) > 1 {
+			for _, doc := range docs {
+				if json.Valid(doc.Output) {
+					return files, fmt.Errorf("Input has multiple documents producing Ignition configs; specify --output-dir")
+				}
+			}
+		}
+		if err := writeManifest(opts.manifest, docs); err != nil {
+			return files, err
+		}
+		if opts.split.Dir != "" {
+			for i := range docs {
+				if !json.Valid(docs[i].Output) {
+					return files, fmt.Errorf("Error splitting config: %v", split.ErrNotIgnition)
+				}
+				docs[i].Output, err = split.Split(docs[i].Output, opts.split)
+				if err != nil {
+					return files, fmt.Errorf("Error splitting config: %v", err)
+				}
+			}
+		}
+		if err := checkSizes(docs, opts); err != nil {
+			return files, err
+		}
+		if opts.wrapPlatform != "" {
+			for i := range docs {
+				docs[i].Output, err = wrap.Wrap(opts.wrapPlatform, docs[i].Output, opts.wrap)
+				if err != nil {
+					return files, fmt.Errorf("Error wrapping config: %v", err)
+				}
+			}
+		}
+		if opts.annotated {
+			for i := range docs {
+				docs[i].Output, err = annotate.Annotate(docs[i].Output, docs[i].Record)
+				if err != nil {
+					return files, fmt.Errorf("Error annotating config: %v", err)
+				}
+			}
+		}
+		if opts.outputDir != "" {
+			if err := writeDocuments(opts.outputDir, docs); err != nil {
+				return files, err
+			}
+			var targets []string
+			for _, doc := range docs {
+				targets = append(targets, filepath.Join(opts.outputDir, doc.Name))
+			}
+			return files, writeDepfile(opts, targets, files)
+		}
+		var outputs [][]byte
+		for _, doc := range docs {
+			outputs = append(outputs, doc.Output)
+		}
+		dataOut = bytes.Join(outputs, []byte("\n---\n"))
+	}
+
+	outfile := os.Stdout
+	if opts.output != "" {
+		var err error
+		outfile, err = os.OpenFile(opts.output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return files, fmt.Errorf("failed to open %s: %v", opts.output, err)
+		}
+		defer outfile.Close()
+	}
+
+	if _, err := outfile.Write(append(dataOut, '\n')); err != nil {
+		return files, fmt.Errorf("Failed to write config to %s: %v", outfile.Name(), err)
+	}
+	return files, writeDepfile(opts, []string{opts.output}, files)
+}
+
+// checkSizes reports the size of each document that exceeds a size limit,
+// or of every document if requested, and fails if a document exceeds
+// --max-size, or --warn-size with --strict.
+func checkSizes(docs []config.Document, opts translateOptions) error {
+	if opts.maxSize.bytes == 0 && opts.warnSize.bytes == 0 && !opts.sizeReport {
+		return nil
+	}
+	failed := 0
+	warned := false
+	for _, doc := range docs {
+		usage, err := budget.Measure(doc.Output, doc.Record)
+		if err != nil {
+			return fmt.Errorf("Error measuring config size: %v", err)
+		}
+		var level string
+		var limit sizeLimit
+		if opts.maxSize.bytes > 0 && usage.Total > opts.maxSize.bytes {
+			level, limit = "error", opts.maxSize
+			failed++
+		} else if opts.warnSize.bytes > 0 && usage.Total > opts.warnSize.bytes {
+			level, limit = "warning", opts.warnSize
+			warned = true
+		} else if opts.sizeReport {
+			level, limit = "info", opts.maxSize
+			if limit.bytes == 0 {
+				limit = opts.warnSize
+			}
+		} else {
+			continue
+		}
+		prefix := ""
+		if len(docs) > 1 {
+			prefix = fmt.Sprintf("document %d: ", doc.Number)
+		}
+		fmt.Fprintf(os.Stderr, "%s%s: %s\n", prefix, level, usage.Report(limit.bytes, limit.description, sizeReportCount))
+	}
+	if failed > 0 {
+		return fmt.Errorf("Config exceeds --max-size")
+	}
+	if opts.strict && warned {
+		return fmt.Errorf("Config exceeds --warn-size and --strict was specified")
+	}
+	return nil
+}
+
+// writeDepfile writes a dependency file for the targets if one was
+// requested.  The input file is a dependency too.
+func writeDepfile(opts translateOptions, targets, files []string) error {
+	if opts.depfile == "" {
+		return nil
+	}
+	var deps []string
+	if opts.input != "" {
+		deps = append(deps, opts.input)
+	}
+	deps = append(deps, files...)
+	if err := ioutil.WriteFile(opts.depfile, depfile.Format(targets, deps), 0644); err != nil {
+		return fmt.Errorf("Failed to write dependency file %s: %v", opts.depfile, err)
+	}
+	return nil
+}
+
+// writeManifest writes a manifest of the translated documents if one was
+// requested.
+func writeManifest(path string, docs []config.Document) error {
+	if path == "" {
+		return nil
+	}
+	type configManifest struct {
+		Output string `json:"output,omitempty"`
+		*manifest.Manifest
+	}
+	out := struct {
+		Configs []configManifest `json:"configs"`
+	}{}
+	for _, doc := range docs {
+		m, err := manifest.Generate(doc.Output, doc.Record)
+		if err != nil {
+			return fmt.Errorf("Error generating manifest: %v", err)
+		}
+		out.Configs = append(out.Configs, configManifest{
+			Output:   doc.Name,
+			Manifest: m,
+		})
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
-		fail("Error translating config: %v\n", err)
+		return fmt.Errorf("Error generating manifest: %v", err)
 	}
-	if strict && len(r.Entries) > 0 {
-		fail("Config produced warnings and --strict was specified\n")
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Failed to write manifest %s: %v", path, err)
 	}
+	return nil
+}
 
-	if output != "" {
+// translateDocuments translates each document in the input with the
+// specified translation options, applying the lint configuration and
+// printing reports as specified by opts.
+func translateDocuments(dataIn []byte, options common.TranslateBytesOptions, opts translateOptions) ([]config.Document, error) {
+	docs := config.TranslateDocuments(dataIn, options)
+	rules := lintDocuments(docs, opts.lint)
+	printer, err := diagnostics.NewPrinter(os.Stderr, opts.reportFormat)
+	if err != nil {
+		return nil, err
+	}
+	printer.Codes = opts.showCodes
+	printer.Rules = len(opts.lint.Rules) > 0
+	failed := 0
+	warned := false
+	for i, doc := range docs {
+		document := 0
+		if len(docs) > 1 {
+			document = doc.Number
+		}
+		printer.Print("", document, doc.Report, rules[i])
+		if doc.Err != nil {
+			if len(docs) == 1 {
+				if err := printer.Flush(); err != nil {
+					return docs, err
+				}
+				return docs, fmt.Errorf("Error translating config: %v", doc.Err)
+			}
+			fmt.Fprintf(os.Stderr, "Error translating document %d: %v\n", doc.Number, doc.Err)
+			failed++
+		}
+		warned = warned || hasWarnings(doc.Report)
+	}
+	if err := printer.Flush(); err != nil {
+		return docs, err
+	}
+	if failed > 0 {
+		return docs, fmt.Errorf("Failed to translate %d of %d documents", failed, len(docs))
+	}
+	if opts.strict && warned {
+		return docs, fmt.Errorf("Config produced warnings and --strict was specified")
+	}
+	return docs, nil
+}
+
+// lintDocuments applies the lint configuration to the reports of the
+// documents, failing any document that violates a rule configured as an
+// error.  It returns the IDs of the rules that produced the entries of
+// each report.
+func lintDocuments(docs []config.Document, lintConfig lint.Config) [][]string {
+	rules := make([][]string, len(docs))
+	for i := range docs {
+		docs[i].Report, rules[i], docs[i].Err = lintTranslation(docs[i].Report, docs[i].Output, docs[i].Record, docs[i].Err, lintConfig)
+	}
+	return rules
+}
+
+// lintTranslation applies the lint configuration to the report of a
+// translation that produced output and record, or failed with err.  It
+// returns the new report, the IDs of the rules that produced its entries,
+// and the error of the translation, which is lint.ErrFailed if the
+// translation succeeded but violates a rule configured as an error.
+func lintTranslation(r report.Report, output []byte, record *common.TranslationRecord, err error, lintConfig lint.Config) (report.Report, []string, error) {
+	var input lint.Input
+	if record != nil {
+		// don't run rules on failed translations, but still
+		// apply suppressions
+		if err != nil {
+			output = nil
+		}
+		input = lint.NewInput(output, record)
+	}
+	r, rules := lint.Lint(r, input, lintConfig)
+	if err == nil && r.IsFatal() {
+		err = lint.ErrFailed
+	}
+	return r, rules, err
+}
+
+// hasWarnings returns true if the report has any warnings, which fail
+// translation with --strict.  Info entries don't.
+func hasWarnings(r report.Report) bool {
+	for _, entry := range r.Entries {
+		if common.EntryKind(entry) == report.Warn {
+			return true
+		}
+	}
+	return false
+}
+
+// loadLintConfig reads the lint configuration from path, or from the
+// nearest lint configuration file above dir if path is empty, and
+// escalates the failOn rules to errors.
+func loadLintConfig(path, dir string, failOn []string) (lint.Config, error) {
+	if path == "" {
 		var err error
+		if path, err = lint.FindConfig(dir); err != nil {
+			return lint.Config{}, fmt.Errorf("finding lint configuration: %v", err)
+		}
+	}
+	var lintConfig lint.Config
+	if path != "" {
+		var err error
+		if lintConfig, err = lint.ReadConfig(path); err != nil {
+			return lint.Config{}, fmt.Errorf("reading lint configuration: %v", err)
+		}
+	}
+	for _, id := range failOn {
+		if err := lintConfig.Set(id, lint.SeverityError); err != nil {
+			return lint.Config{}, fmt.Errorf("--fail-on: %v", err)
+		}
+	}
+	return lintConfig, nil
+}
+
+// writeDocuments writes each translated document to a file in outputDir.
+func writeDocuments(outputDir string, docs []config.Document) error {
+	seen := make(map[string]int)
+	for _, doc := range docs {
+		if doc.Name == "" {
+			return fmt.Errorf("Document %d has no output filename; specify one with a top-level output field", doc.Number)
+		}
+		if doc.Name != filepath.Base(doc.Name) || doc.Name == "." || doc.Name == ".." {
+			return fmt.Errorf("Document %d has invalid output filename %q", doc.Number, doc.Name)
+		}
+		if other, ok := seen[doc.Name]; ok {
+			return fmt.Errorf("Documents %d and %d have the same output filename %q", other, doc.Number, doc.Name)
+		}
+		seen[doc.Name] = doc.Number
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", outputDir, err)
+	}
+	for _, doc := range docs {
+		filename := filepath.Join(outputDir, doc.Name)
+		if err := ioutil.WriteFile(filename, append(doc.Output, '\n'), 0644); err != nil {
+			return fmt.Errorf("Failed to write config to %s: %v", filename, err)
+		}
+	}
+	return nil
+}
+
+// minimumVersion returns a description of the lowest spec version that can
+// translate the config, and of the problems with each older version,
+// optionally including their error codes.
+func minimumVersion(dataIn []byte, options common.TranslateBytesOptions, showCodes bool) ([]byte, error) {
+	min, reports, err := config.MinimumVersion(dataIn, options)
+	if err != nil {
+		return nil, fmt.Errorf("Error checking spec versions: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, vr := range reports {
+		fmt.Fprintf(&buf, "%s: unusable\n", vr.Version)
+		r := vr.Report
+		if showCodes {
+			r = diagnostics.Tag(r)
+		}
+		for _, entry := range r.Entries {
+			fmt.Fprintf(&buf, "  %s\n", entry)
+		}
+		if len(vr.Report.Entries) == 0 && vr.Err != nil {
+			fmt.Fprintf(&buf, "  %v\n", vr.Err)
+		}
+	}
+	if min == nil {
+		fmt.Fprint(os.Stderr, buf.String())
+		return nil, fmt.Errorf("No spec version can translate the config")
+	}
+	fmt.Fprintf(&buf, "%s: minimum version", min)
+	return buf.Bytes(), nil
+}
+
+func diffMain(args []string) {
+	var (
+		strict    bool
+		lintPath  string
+		failOn    []string
+		showCodes bool
+		helpFlag  bool
+	)
+	options := common.TranslateBytesOptions{}
+	flags := pflag.NewFlagSet("diff", pflag.ExitOnError)
+	flags.BoolVarP(&helpFlag, "help", "h", false, "show usage and exit")
+	flags.BoolVarP(&strict, "strict", "s", false, "fail on any warning")
+	flags.StringVar(&lintPath, "lint-config", "", fmt.Sprintf("read lint rule settings from this file instead of the nearest %s", lint.ConfigFileName))
+	flags.StringSliceVar(&failOn, "fail-on", nil, "fail if any of these comma-separated lint rules are triggered")
+	flags.BoolVar(&showCodes, "show-codes", false, "prefix warnings and errors with their error codes")
+	flags.BoolVarP(&options.Raw, "raw", "r", false, "never wrap in a MachineConfig; force Ignition output")
+	addTranslateFlags(flags, &options.TranslateOptions)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s diff [options] old-file new-file\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Translate two configs and report differences between the results.\n")
+		fmt.Fprintf(flags.Output(), "Exits 0 if the results are equivalent, 1 if they differ, or 2 on error.\n")
+		fmt.Fprintf(flags.Output(), "Options:\n")
+		flags.PrintDefaults()
+	}
+	// ignore error; ExitOnError
+	_ = flags.Parse(args)
+
+	if helpFlag {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+		os.Exit(0)
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	translateForDiff := func(filename string) diff.Config {
+		dataIn, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", filename, err)
+			os.Exit(2)
+		}
+		lintConfig, err := loadLintConfig(lintPath, filepath.Dir(filename), failOn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+		opts := options
+		opts.Record = &common.TranslationRecord{}
+		dataOut, r, err := config.TranslateBytes(dataIn, opts)
+		r, rules, err := lintTranslation(r, dataOut, opts.Record, err, lintConfig)
+		printer, printErr := diagnostics.NewPrinter(os.Stderr, diagnostics.FormatText)
+		if printErr != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", printErr)
+			os.Exit(2)
+		}
+		printer.Codes = showCodes
+		printer.Rules = len(lintConfig.Rules) > 0
+		printer.Print(filename, 0, r, rules)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error translating %s: %v\n", filename, err)
+			os.Exit(2)
+		}
+		if strict && hasWarnings(r) {
+			fmt.Fprintf(os.Stderr, "%s produced warnings and --strict was specified\n", filename)
+			os.Exit(2)
+		}
+		return diff.Config{
+			Output: dataOut,
+			Record: opts.Record,
+		}
+	}
+	oldFile := flags.Arg(0)
+	newFile := flags.Arg(1)
+	changes, err := diff.Diff(translateForDiff(oldFile), translateForDiff(newFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error comparing configs: %v\n", err)
+		os.Exit(2)
+	}
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Printf("--- %s\n+++ %s\n", oldFile, newFile)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	os.Exit(1)
+}
+
+func mergeMain(args []string) {
+	var (
+		output    string
+		strict    bool
+		lintPath  string
+		failOn    []string
+		translate bool
+		showCodes bool
+		helpFlag  bool
+	)
+	options := common.TranslateBytesOptions{}
+	flags := pflag.NewFlagSet("merge", pflag.ExitOnError)
+	flags.BoolVarP(&helpFlag, "help", "h", false, "show usage and exit")
+	flags.BoolVarP(&translate, "translate", "t", false, "translate the merged config instead of outputting it")
+	flags.BoolVarP(&strict, "strict", "s", false, "fail on any warning")
+	flags.StringVar(&lintPath, "lint-config", "", fmt.Sprintf("read lint rule settings from this file instead of the nearest %s", lint.ConfigFileName))
+	flags.StringSliceVar(&failOn, "fail-on", nil, "fail if any of these comma-separated lint rules are triggered")
+	flags.BoolVar(&showCodes, "show-codes", false, "prefix warnings and errors with their error codes")
+	flags.BoolVarP(&options.Pretty, "pretty", "p", false, "output formatted json")
+	flags.BoolVarP(&options.Raw, "raw", "r", false, "never wrap in a MachineConfig; force Ignition output")
+	flags.StringVarP(&output, "output", "o", "", "write to output file instead of stdout")
+	addTranslateFlags(flags, &options.TranslateOptions)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s merge [options] base-file overlay-file...\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Merge configs of the same variant and version into a single config.\n")
+		fmt.Fprintf(flags.Output(), "Each config overrides the ones before it.\n")
+		fmt.Fprintf(flags.Output(), "Options:\n")
+		flags.PrintDefaults()
+	}
+	// ignore error; ExitOnError
+	_ = flags.Parse(args)
+
+	if helpFlag {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+		os.Exit(0)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var fragments []merge.Fragment
+	for _, filename := range flags.Args() {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fail("failed to read %s: %v\n", filename, err)
+		}
+		fragments = append(fragments, merge.Fragment{
+			Name: filename,
+			Data: data,
+		})
+	}
+	merged, err := merge.Merge(fragments, options.TranslateOptions)
+	if err != nil {
+		fail("Error merging configs: %v\n", err)
+	}
+
+	var dataOut []byte
+	if translate {
+		lintConfig, err := loadLintConfig(lintPath, filepath.Dir(flags.Arg(0)), failOn)
+		if err != nil {
+			fail("%v\n", err)
+		}
+		printer, err := diagnostics.NewPrinter(os.Stderr, diagnostics.FormatText)
+		if err != nil {
+			fail("%v\n", err)
+		}
+		printer.Codes = showCodes
+		printer.Rules = len(lintConfig.Rules) > 0
+
+		input, err := merged.Bytes()
+		if err != nil {
+			fail("Error writing merged config: %v\n", err)
+		}
+		// lint before pointing the report at the merged configs,
+		// since suppressions are found by line in the merged config
+		options.Record = &common.TranslationRecord{}
+		var r report.Report
+		var rules []string
+		dataOut, r, err = config.TranslateBytes(input, options)
+		r, rules, err = lintTranslation(r, dataOut, options.Record, err, lintConfig)
+		merged.MapReport(&r)
+		printer.Print("", 0, r, rules)
+		if err != nil {
+			fail("Error translating config: %v\n", err)
+		}
+		if strict && hasWarnings(r) {
+			fail("Config produced warnings and --strict was specified\n")
+		}
+		dataOut = append(dataOut, '\n')
+	} else {
+		dataOut, err = merged.Bytes()
+		if err != nil {
+			fail("Error writing merged config: %v\n", err)
+		}
+	}
+
+	outfile := os.Stdout
+	if output != "" {
 		outfile, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 		if err != nil {
 			fail("failed to open %s: %v\n", output, err)
 		}
 		defer outfile.Close()
 	}
-
-	if _, err := outfile.Write(append(dataOut, '\n')); err != nil {
+	if _, err := outfile.Write(dataOut); err != nil {
 		fail("Failed to write config to %s: %v\n", outfile.Name(), err)
 	}
 }
+
+func buildMain(args []string) {
+	var (
+		outDir       string
+		lintPath     string
+		failOn       []string
+		reportFormat string
+		showCodes    bool
+		helpFlag     bool
+	)
+	options := build.Options{}
+	flags := pflag.NewFlagSet("build", pflag.ExitOnError)
+	flags.BoolVarP(&helpFlag, "help", "h", false, "show usage and exit")
+	flags.BoolVarP(&options.Strict, "strict", "s", false, "fail on any warning")
+	flags.StringVar(&lintPath, "lint-config", "", fmt.Sprintf("read lint rule settings from this file instead of the nearest %s", lint.ConfigFileName))
+	flags.StringSliceVar(&failOn, "fail-on", nil, "fail if any of these comma-separated lint rules are triggered")
+	flags.StringVar(&reportFormat, "report-format", diagnostics.FormatText, fmt.Sprintf("format of warnings and errors (%s)", strings.Join(diagnostics.Formats(), ", ")))
+	flags.BoolVar(&showCodes, "show-codes", false, "prefix text warnings and errors with their error codes")
+	flags.BoolVarP(&options.Pretty, "pretty", "p", false, "output formatted json")
+	flags.BoolVarP(&options.Raw, "raw", "r", false, "never wrap in a MachineConfig; force Ignition output")
+	flags.IntVarP(&options.Jobs, "jobs", "j", runtime.NumCPU(), "number of configs to translate concurrently")
+	flags.StringVarP(&outDir, "output", "o", "", "write outputs to this directory")
+	addTranslateFlags(flags, &options.TranslateOptions)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s build [options] -o output-dir source-dir\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Translate every .bu file under source-dir, writing .ign or .yaml files\n")
+		fmt.Fprintf(flags.Output(), "to the same relative paths under output-dir.\n")
+		fmt.Fprintf(flags.Output(), "Options:\n")
+		flags.PrintDefaults()
+	}
+	// ignore error; ExitOnError
+	_ = flags.Parse(args)
+
+	if helpFlag {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+		os.Exit(0)
+	}
+	if flags.NArg() != 1 || outDir == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	printer, err := diagnostics.NewPrinter(os.Stderr, reportFormat)
+	if err != nil {
+		fail("--report-format: %v\n", err)
+	}
+	printer.Codes = showCodes
+	if options.Lint, err = loadLintConfig(lintPath, flags.Arg(0), failOn); err != nil {
+		fail("%v\n", err)
+	}
+	printer.Rules = len(options.Lint.Rules) > 0
+
+	results, err := build.Build(flags.Arg(0), outDir, options)
+	if err != nil {
+		fail("Error reading configs: %v\n", err)
+	}
+	failed := 0
+	warned := 0
+	for _, result := range results {
+		printer.Print(result.Path, 0, result.Report, result.Rules)
+		if result.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Error translating config: %v\n", result.Path, result.Err)
+			failed++
+		} else if len(result.Report.Entries) > 0 {
+			warned++
+		}
+	}
+	if err := printer.Flush(); err != nil {
+		fail("Error writing report: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "Translated %d configs: %d failed, %d with warnings\n", len(results), failed, warned)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package manifest lists the filesystem nodes and systemd units in a
+// translated config, with hashes of their contents and the Butane config
+// fields they came from.
+package manifest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	slashpath "path"
+	"strings"
+
+	baseutil "github.com/coreos/butane/base/util"
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/butane/internal/outputdoc"
+
+	"github.com/coreos/vcontext/path"
+	"gopkg.in/yaml.v3"
+)
+
+// Origin types
+const (
+	OriginInline    = "inline"    // contents specified in the config
+	OriginLocal     = "local"     // contents read from the files directory
+	OriginTree      = "tree"      // node created from a local tree
+	OriginRemote    = "remote"    // contents fetched from a URL, at boot or by --embed-remote
+	OriginConfig    = "config"    // node specified in the config
+	OriginGenerated = "generated" // node or contents synthesized by Butane
+)
+
+type Manifest struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Entry describes a file, directory, link, systemd unit, or unit dropin.
+type Entry struct {
+	// Kind is "file", "directory", "link", "unit", or "dropin".
+	Kind string `json:"kind"`
+	// Path is the path of a filesystem node, the name of a unit, or
+	// the path of a dropin relative to the systemd unit directory.
+	Path     string    `json:"path"`
+	Mode     *int      `json:"mode,omitempty"`
+	User     *Owner    `json:"user,omitempty"`
+	Group    *Owner    `json:"group,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Contents []Content `json:"contents,omitempty"`
+	Origin   Origin    `json:"origin"`
+}
+
+type Owner struct {
+	ID   *int   `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Content describes the contents of a file or unit, or data appended to
+// a file.  Hashes and size are of the decoded, uncompressed contents, and
+// are omitted for remote contents.
+type Content struct {
+	// Role is "contents" or "append".
+	Role   string `json:"role"`
+	Size   *int   `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	SHA512 string `json:"sha512,omitempty"`
+	// Compression is the compression applied to the contents in the
+	// output config.
+	Compression string `json:"compression,omitempty"`
+	// Verification is the hash of remote contents that Ignition will
+	// verify, if specified.
+	Verification string `json:"verification,omitempty"`
+	Origin       Origin `json:"origin"`
+}
+
+// Origin describes where a node or its contents came from.
+type Origin struct {
+	Type string `json:"type"`
+	// Path is the path of a local file relative to the files
+	// directory.
+	Path string `json:"path,omitempty"`
+	// Source is the URL of remote contents.
+	Source string `json:"source,omitempty"`
+	// Field is the Butane config field that produced the node or
+	// contents.
+	Field string `json:"field,omitempty"`
+}
+
+type generator struct {
+	record *common.TranslationRecord
+	// the Butane config
+	config interface{}
+	// path to the Ignition config within the output
+	ignPath []interface{}
+}
+
+// Generate returns a manifest of the output of a translation.  record must
+// be the TranslationRecord of the translation.
+func Generate(output []byte, record *common.TranslationRecord) (*Manifest, error) {
+	doc, err := outputdoc.Parse(output)
+	if err != nil {
+		return nil, fmt.Errorf("parsing output: %w", err)
+	}
+	g := generator{
+		record: record,
+	}
+	if err := yaml.Unmarshal(record.Config, &g.config); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	var ign map[string]interface{}
+	ign, g.ignPath = outputdoc.Ignition(doc)
+
+	m := Manifest{
+		Entries: []Entry{},
+	}
+	storage := outputdoc.Map(ign, "storage")
+	for i, v := range outputdoc.List(storage, "files") {
+		entry, err := g.node("file", v, "storage", "files", i)
+		if err != nil {
+			return nil, err
+		}
+		file := v.(map[string]interface{})
+		if contents := outputdoc.Map(file, "contents"); contents["source"] != nil {
+			c, err := g.resource(contents, "contents", entry.Path, "storage", "files", i, "contents")
+			if err != nil {
+				return nil, err
+			}
+			entry.Contents = append(entry.Contents, c)
+		}
+		for j, res := range outputdoc.List(file, "append") {
+			c, err := g.resource(res.(map[string]interface{}), "append", entry.Path, "storage", "files", i, "append", j)
+			if err != nil {
+				return nil, err
+			}
+			entry.Contents = append(entry.Contents, c)
+		}
+		m.Entries = append(m.Entries, entry)
+	}
+	for i, v := range outputdoc.List(storage, "directories") {
+		entry, err := g.node("directory", v, "storage", "directories", i)
+		if err != nil {
+			return nil, err
+		}
+		m.Entries = append(m.Entries, entry)
+	}
+	for i, v := range outputdoc.List(storage, "links") {
+		entry, err := g.node("link", v, "storage", "links", i)
+		if err != nil {
+			return nil, err
+		}
+		entry.Target, _ = v.(map[string]interface{})["target"].(string)
+		m.Entries = append(m.Entries, entry)
+	}
+	for i, v := range outputdoc.List(outputdoc.Map(ign, "systemd"), "units") {
+		unit := v.(map[string]interface{})
+		name, _ := unit["name"].(string)
+		m.Entries = append(m.Entries, Entry{
+			Kind:     "unit",
+			Path:     name,
+			Contents: g.text(unit, "systemd", "units", i),
+			Origin:   g.nodeOrigin("", []interface{}{"systemd", "units", i, "name"}),
+		})
+		for j, d := range outputdoc.List(unit, "dropins") {
+			dropin := d.(map[string]interface{})
+			dropinName, _ := dropin["name"].(string)
+			m.Entries = append(m.Entries, Entry{
+				Kind:     "dropin",
+				Path:     name + ".d/" + dropinName,
+				Contents: g.text(dropin, "systemd", "units", i, "dropins", j),
+				Origin:   g.nodeOrigin("", []interface{}{"systemd", "units", i, "dropins", j, "name"}),
+			})
+		}
+	}
+	return &m, nil
+}
+
+// node returns an entry for a filesystem node at the specified path
+// relative to the Ignition config.
+func (g *generator) node(kind string, v interface{}, p ...interface{}) (Entry, error) {
+	node, ok := v.(map[string]interface{})
+	if !ok {
+		return Entry{}, fmt.Errorf("%s is not an object", path.New("json", p...))
+	}
+	entry := Entry{
+		Kind:  kind,
+		User:  owner(outputdoc.Map(node, "user")),
+		Group: owner(outputdoc.Map(node, "group")),
+	}
+	entry.Path, _ = node["path"].(string)
+	if mode, ok := toInt(node["mode"]); ok {
+		entry.Mode = &mode
+	}
+	entry.Origin = g.nodeOrigin(entry.Path, append(p, "path"))
+	return entry, nil
+}
+
+// resource describes an Ignition resource at the specified path relative
+// to the Ignition config.
+func (g *generator) resource(res map[string]interface{}, role, nodePath string, p ...interface{}) (Content, error) {
+	c := Content{
+		Role: role,
+	}
+	source, _ := res["source"].(string)
+	var compression *string
+	if s, ok := res["compression"].(string); ok && s != "" {
+		c.Compression = s
+		compression = &s
+	}
+	c.Verification, _ = outputdoc.Map(res, "verification")["hash"].(string)
+	c.Origin = g.contentOrigin(nodePath, append(p, "source"))
+	if strings.HasPrefix(source, "data:") {
+		contents, err := baseutil.DecodeDataURL(source, compression)
+		if err != nil {
+			return Content{}, fmt.Errorf("decoding %s: %w", path.New("json", p...), err)
+		}
+		c.setHashes(contents)
+	} else {
+		c.Origin.Type = OriginRemote
+		c.Origin.Source = source
+	}
+	return c, nil
+}
+
+// text describes the inline contents of a unit or dropin at the
+// specified path relative to the Ignition config.
+func (g *generator) text(m map[string]interface{}, p ...interface{}) []Content {
+	contents, ok := m["contents"].(string)
+	if !ok {
+		return nil
+	}
+	c := Content{
+		Role:   "contents",
+		Origin: g.contentOrigin("", append(p, "contents")),
+	}
+	c.setHashes([]byte(contents))
+	return []Content{c}
+}
+
+func (c *Content) setHashes(contents []byte) {
+	size := len(contents)
+	sum256 := sha256.Sum256(contents)
+	sum512 := sha512.Sum512(contents)
+	c.Size = &size
+	c.SHA256 = hex.EncodeToString(sum256[:])
+	c.SHA512 = hex.EncodeToString(sum512[:])
+}
+
+// nodeOrigin returns the origin of a node, given the path of its key
+// field relative to the Ignition config.
+func (g *generator) nodeOrigin(nodePath string, p []interface{}) Origin {
+	from, ok := g.source(p)
+	if !ok {
+		return Origin{
+			Type: OriginGenerated,
+		}
+	}
+	if o, ok := g.treeOrigin(from, nodePath); ok {
+		return o
+	}
+	if last, _ := from.Path[len(from.Path)-1].(string); last == "path" || last == "name" {
+		return Origin{
+			Type:  OriginConfig,
+			Field: from.Copy().Pop().String(),
+		}
+	}
+	return Origin{
+		Type:  OriginGenerated,
+		Field: from.String(),
+	}
+}
+
+// contentOrigin returns the origin of contents, given their path relative
+// to the Ignition config.
+func (g *generator) contentOrigin(nodePath string, p []interface{}) Origin {
+	from, ok := g.source(p)
+	if !ok {
+		return Origin{
+			Type: OriginGenerated,
+		}
+	}
+	if o, ok := g.treeOrigin(from, nodePath); ok {
+		return o
+	}
+	o := Origin{
+		Field: from.String(),
+	}
+	switch from.Path[len(from.Path)-1] {
+	case "inline", "contents", "json", "yaml", "toml", "ini":
+		o.Type = OriginInline
+	case "local", "contents_local":
+		o.Type = OriginLocal
+		o.Path, _ = outputdoc.Value(g.config, from).(string)
+	case "source":
+		// data URLs are inline unless they embed a remote resource;
+		// callers handle other URLs
+		o.Type = OriginInline
+		if url, ok := g.record.EmbeddedSources[g.outputPath(p).String()]; ok {
+			o.Type = OriginRemote
+			o.Source = url
+		}
+	default:
+		o.Type = OriginGenerated
+	}
+	return o
+}
+
+// treeOrigin returns the origin of a node created from a local tree.
+func (g *generator) treeOrigin(from path.ContextPath, nodePath string) (Origin, bool) {
+	if len(from.Path) != 3 || from.Path[0] != "storage" || from.Path[1] != "trees" {
+		return Origin{}, false
+	}
+	local, _ := outputdoc.Value(g.config, from.Append("local")).(string)
+	destBase, _ := outputdoc.Value(g.config, from.Append("path")).(string)
+	if destBase == "" {
+		destBase = "/"
+	}
+	rel := strings.TrimPrefix(slashpath.Clean(nodePath), slashpath.Clean(destBase))
+	return Origin{
+		Type:  OriginTree,
+		Path:  slashpath.Join(local, rel),
+		Field: from.String(),
+	}, true
+}
+
+// outputPath returns the path in the output of the specified path
+// relative to the Ignition config.
+func (g *generator) outputPath(p []interface{}) path.ContextPath {
+	return path.New("json", append(append([]interface{}{}, g.ignPath...), p...)...)
+}
+
+// source returns the Butane config path that produced the specified path
+// relative to the Ignition config.
+func (g *generator) source(p []interface{}) (path.ContextPath, bool) {
+	t, ok := g.record.Translations.Set[g.outputPath(p).String()]
+	if !ok || len(t.From.Path) == 0 {
+		return path.ContextPath{}, false
+	}
+	return t.From, true
+}
+
+func owner(m map[string]interface{}) *Owner {
+	var o Owner
+	if id, ok := toInt(m["id"]); ok {
+		o.ID = &id
+	}
+	o.Name, _ = m["name"].(string)
+	if o.ID == nil && o.Name == "" {
+		return nil
+	}
+	return &o
+}
+
+func toInt(v interface{}) (int, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return 0, false
+	}
+	return int(i), true
+}
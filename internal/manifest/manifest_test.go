@@ -0,0 +1,310 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestGenerate(t *testing.T) {
+	filesDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(filesDir, "tree", "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"local.txt":         "hello\n",
+		"tree/etc/tree.txt": "z",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(filesDir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// hashes of "z"
+	zSHA512 := "5ae625665f3e0bd0a065ed07a41989e4025b79d13930a2a8c57d6b4325226707d956a082d1e91b4d96a793562df98fd03c9dcf743c9c7b4e3055d4f9f09ba015"
+	zSHA256 := "594e519ae499312b29433b7dd8a97ff068defcba9755b6d5d00e84c524d67b06"
+	// hashes of "hello\n"
+	hello256 := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	hello512 := "e7c22b994c59d9cf2b48e549b1e24666636045930d3da7c1acb299d1c3b7f931f94aae41edda2c2b207a36e10f8bcb8d45223e54878f5b316e7ce3b6bc019629"
+	// compressible contents
+	long := strings.Repeat("z", 100)
+	long256 := "bd7475717a88f13dc3864a91c12fb7d155e7cccc8ca9430ef2665db2d2df7f2e"
+	long512 := "1919868e02e733906068942f4f5be4b5ac35559d3eea9570b6b132ef5d155cbc79425eeac07965e051952213b601ece74f505010493b84a59943e2057fcfda70"
+
+	tests := []struct {
+		in  string
+		out []Entry
+	}{
+		// empty config
+		{
+			"variant: fcos\nversion: 1.4.0",
+			[]Entry{},
+		},
+		// inline, local, and remote contents
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /inline
+      mode: 0644
+      user:
+        name: core
+      group:
+        id: 10
+      contents:
+        inline: z
+    - path: /local
+      contents:
+        local: local.txt
+      append:
+        - source: https://example.com/z
+          verification:
+            hash: sha512-` + zSHA512 + `
+  links:
+    - path: /link
+      target: /inline`,
+			[]Entry{
+				{
+					Kind:  "file",
+					Path:  "/inline",
+					Mode:  intPtr(0644),
+					User:  &Owner{Name: "core"},
+					Group: &Owner{ID: intPtr(10)},
+					Contents: []Content{
+						{
+							Role:   "contents",
+							Size:   intPtr(1),
+							SHA256: zSHA256,
+							SHA512: zSHA512,
+							Origin: Origin{Type: OriginInline, Field: "$.storage.files.0.contents.inline"},
+						},
+					},
+					Origin: Origin{Type: OriginConfig, Field: "$.storage.files.0"},
+				},
+				{
+					Kind: "file",
+					Path: "/local",
+					Contents: []Content{
+						{
+							Role:   "contents",
+							Size:   intPtr(6),
+							SHA256: hello256,
+							SHA512: hello512,
+							Origin: Origin{Type: OriginLocal, Path: "local.txt", Field: "$.storage.files.1.contents.local"},
+						},
+						{
+							Role:         "append",
+							Verification: "sha512-" + zSHA512,
+							Origin:       Origin{Type: OriginRemote, Source: "https://example.com/z", Field: "$.storage.files.1.append.0.source"},
+						},
+					},
+					Origin: Origin{Type: OriginConfig, Field: "$.storage.files.1"},
+				},
+				{
+					Kind:   "link",
+					Path:   "/link",
+					Target: "/inline",
+					Origin: Origin{Type: OriginConfig, Field: "$.storage.links.0"},
+				},
+			},
+		},
+		// trees, compression, and units
+		{
+			`variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /compressed
+      contents:
+        inline: ` + long + `
+  trees:
+    - local: tree
+      path: /usr/local
+systemd:
+  units:
+    - name: z.service
+      contents: z
+      dropins:
+        - name: z.conf
+          contents: z`,
+			[]Entry{
+				{
+					Kind: "file",
+					Path: "/compressed",
+					Contents: []Content{
+						{
+							Role:        "contents",
+							Size:        intPtr(100),
+							SHA256:      long256,
+							SHA512:      long512,
+							Compression: "gzip",
+							Origin:      Origin{Type: OriginInline, Field: "$.storage.files.0.contents.inline"},
+						},
+					},
+					Origin: Origin{Type: OriginConfig, Field: "$.storage.files.0"},
+				},
+				{
+					Kind: "file",
+					Path: "/usr/local/etc/tree.txt",
+					Mode: intPtr(0644),
+					Contents: []Content{
+						{
+							Role:   "contents",
+							Size:   intPtr(1),
+							SHA256: zSHA256,
+							SHA512: zSHA512,
+							Origin: Origin{Type: OriginTree, Path: "tree/etc/tree.txt", Field: "$.storage.trees.0"},
+						},
+					},
+					Origin: Origin{Type: OriginTree, Path: "tree/etc/tree.txt", Field: "$.storage.trees.0"},
+				},
+				{
+					Kind: "unit",
+					Path: "z.service",
+					Contents: []Content{
+						{
+							Role:   "contents",
+							Size:   intPtr(1),
+							SHA256: zSHA256,
+							SHA512: zSHA512,
+							Origin: Origin{Type: OriginInline, Field: "$.systemd.units.0.contents"},
+						},
+					},
+					Origin: Origin{Type: OriginConfig, Field: "$.systemd.units.0"},
+				},
+				{
+					Kind: "dropin",
+					Path: "z.service.d/z.conf",
+					Contents: []Content{
+						{
+							Role:   "contents",
+							Size:   intPtr(1),
+							SHA256: zSHA256,
+							SHA512: zSHA512,
+							Origin: Origin{Type: OriginInline, Field: "$.systemd.units.0.dropins.0.contents"},
+						},
+					},
+					Origin: Origin{Type: OriginConfig, Field: "$.systemd.units.0.dropins.0"},
+				},
+			},
+		},
+		// MachineConfig
+		{
+			`variant: openshift
+version: 4.10.0
+metadata:
+  name: z
+  labels:
+    machineconfiguration.openshift.io/role: worker
+storage:
+  files:
+    - path: /z
+      contents:
+        inline: z`,
+			[]Entry{
+				{
+					Kind: "file",
+					Path: "/z",
+					Contents: []Content{
+						{
+							Role:   "contents",
+							Size:   intPtr(1),
+							SHA256: zSHA256,
+							SHA512: zSHA512,
+							Origin: Origin{Type: OriginInline, Field: "$.storage.files.0.contents.inline"},
+						},
+					},
+					Origin: Origin{Type: OriginConfig, Field: "$.storage.files.0"},
+				},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("generate %d", i), func(t *testing.T) {
+			options := common.TranslateBytesOptions{}
+			options.FilesDir = filesDir
+			options.Record = &common.TranslationRecord{}
+			out, _, err := config.TranslateBytes([]byte(test.in), options)
+			if err != nil {
+				t.Fatalf("translation failed: %v", err)
+			}
+			m, err := Generate(out, options.Record)
+			if !assert.NoError(t, err, "generation failed") {
+				return
+			}
+			assert.Equal(t, test.out, m.Entries, "bad manifest")
+		})
+	}
+}
+
+func TestGenerateEmbedded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("z"))
+	}))
+	defer server.Close()
+
+	in := `variant: fcos
+version: 1.4.0
+storage:
+  files:
+    - path: /embedded
+      contents:
+        source: ` + server.URL + `/z`
+	options := common.TranslateBytesOptions{}
+	options.EmbedRemote = true
+	options.Record = &common.TranslationRecord{}
+	out, _, err := config.TranslateBytes([]byte(in), options)
+	if err != nil {
+		t.Fatalf("translation failed: %v", err)
+	}
+	m, err := Generate(out, options.Record)
+	if !assert.NoError(t, err, "generation failed") {
+		return
+	}
+	assert.Equal(t, []Entry{
+		{
+			Kind: "file",
+			Path: "/embedded",
+			Contents: []Content{
+				{
+					Role:   "contents",
+					Size:   intPtr(1),
+					SHA256: "594e519ae499312b29433b7dd8a97ff068defcba9755b6d5d00e84c524d67b06",
+					SHA512: "5ae625665f3e0bd0a065ed07a41989e4025b79d13930a2a8c57d6b4325226707d956a082d1e91b4d96a793562df98fd03c9dcf743c9c7b4e3055d4f9f09ba015",
+					Origin: Origin{Type: OriginRemote, Source: server.URL + "/z", Field: "$.storage.files.0.contents.source"},
+				},
+			},
+			Origin: Origin{Type: OriginConfig, Field: "$.storage.files.0"},
+		},
+	}, m.Entries, "bad manifest")
+}
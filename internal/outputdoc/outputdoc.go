@@ -0,0 +1,94 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package outputdoc decodes translated configs for the commands that
+// inspect them.
+package outputdoc
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/coreos/vcontext/path"
+	"gopkg.in/yaml.v3"
+)
+
+// Parse decodes an output config, converting YAML to JSON types.
+// Numbers are decoded as json.Number.
+func Parse(output []byte) (map[string]interface{}, error) {
+	data := output
+	if !json.Valid(data) {
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Ignition returns the Ignition config in a parsed output config, and
+// its path within the output: the output itself, or spec.config of a
+// MachineConfig.
+func Ignition(doc map[string]interface{}) (map[string]interface{}, []interface{}) {
+	if doc["kind"] == "MachineConfig" {
+		return Map(Map(doc, "spec"), "config"), []interface{}{"spec", "config"}
+	}
+	return doc, nil
+}
+
+// Map returns the object at key in m, or nil if there isn't one.
+func Map(m map[string]interface{}, key string) map[string]interface{} {
+	ret, _ := m[key].(map[string]interface{})
+	return ret
+}
+
+// List returns the array at key in m, or nil if there isn't one.
+func List(m map[string]interface{}, key string) []interface{} {
+	ret, _ := m[key].([]interface{})
+	return ret
+}
+
+// Value returns the value at the specified path in a decoded config, or
+// nil if there isn't one.
+func Value(v interface{}, p path.ContextPath) interface{} {
+	for _, e := range p.Path {
+		switch e := e.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[e]
+		case int:
+			l, ok := v.([]interface{})
+			if !ok || e < 0 || e >= len(l) {
+				return nil
+			}
+			v = l[e]
+		default:
+			return nil
+		}
+	}
+	return v
+}
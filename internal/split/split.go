@@ -0,0 +1,204 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package split moves large file contents out of an Ignition config into
+// separately hosted blobs.
+package split
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	baseutil "github.com/coreos/butane/base/util"
+
+	"github.com/clarketm/json"
+	v3_0 "github.com/coreos/ignition/v2/config/v3_0/types"
+	v3_1 "github.com/coreos/ignition/v2/config/v3_1/types"
+	v3_2 "github.com/coreos/ignition/v2/config/v3_2/types"
+	v3_3 "github.com/coreos/ignition/v2/config/v3_3/types"
+	v3_4_exp "github.com/coreos/ignition/v2/config/v3_4_experimental/types"
+	"github.com/coreos/vcontext/validate"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// DefaultThreshold is the default length of the largest data URL that
+// stays in the config.
+const DefaultThreshold = 4096
+
+var (
+	ErrNotIgnition = errors.New("only Ignition configs can be split")
+
+	// constructors for the Ignition config types, by version
+	configTypes = map[string]func() interface{}{
+		"3.0.0":              func() interface{} { return &v3_0.Config{} },
+		"3.1.0":              func() interface{} { return &v3_1.Config{} },
+		"3.2.0":              func() interface{} { return &v3_2.Config{} },
+		"3.3.0":              func() interface{} { return &v3_3.Config{} },
+		"3.4.0-experimental": func() interface{} { return &v3_4_exp.Config{} },
+	}
+)
+
+// Options are the settings for splitting a config.
+type Options struct {
+	BaseURL   string // URL at which the contents of Dir will be hosted
+	Dir       string // directory for writing blobs
+	Threshold int    // length of the largest data URL to keep in the config
+	Pointer   bool   // also host the config, and return a pointer to it
+	Pretty    bool   // format the returned and hosted configs
+}
+
+// Split writes the contents of files and file appends whose data URLs are
+// longer than opts.Threshold to blobs in opts.Dir, named by their SHA-256
+// hashes, and replaces each data URL with the blob's URL under
+// opts.BaseURL and a verification hash.  If opts.Pointer is set, the
+// resulting config is also written to opts.Dir, and a config that replaces
+// itself with the hosted one is returned instead.  Both the hosted config
+// and the returned one are validated.
+func Split(config []byte, opts Options) ([]byte, error) {
+	var header struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal(config, &header); err != nil {
+		return nil, err
+	}
+	newConfig, ok := configTypes[header.Ignition.Version]
+	if !ok {
+		return nil, ErrNotIgnition
+	}
+	cfg := newConfig()
+	if err := json.Unmarshal(config, cfg); err != nil {
+		return nil, err
+	}
+
+	files := reflect.ValueOf(cfg).Elem().FieldByName("Storage").FieldByName("Files")
+	for i := 0; i < files.Len(); i++ {
+		file := files.Index(i)
+		if err := splitResource(file.FieldByName("Contents"), opts); err != nil {
+			return nil, fmt.Errorf("splitting contents of %s: %w", file.FieldByName("Path").String(), err)
+		}
+		appends := file.FieldByName("Append")
+		for j := 0; j < appends.Len(); j++ {
+			if err := splitResource(appends.Index(j), opts); err != nil {
+				return nil, fmt.Errorf("splitting append %d of %s: %w", j, file.FieldByName("Path").String(), err)
+			}
+		}
+	}
+	out, err := marshalValid(cfg, opts.Pretty)
+	if err != nil {
+		return nil, fmt.Errorf("split config is invalid: %w", err)
+	}
+	if !opts.Pointer {
+		return out, nil
+	}
+
+	name, err := writeBlob(opts.Dir, out, ".ign")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha512.Sum512(out)
+	hash := "sha512-" + hex.EncodeToString(sum[:])
+	source := blobURL(opts.BaseURL, name)
+
+	// keep the settings needed to fetch the hosted config
+	pointer := newConfig()
+	ign := reflect.ValueOf(pointer).Elem().FieldByName("Ignition")
+	ign.Set(reflect.ValueOf(cfg).Elem().FieldByName("Ignition"))
+	replace := ign.FieldByName("Config")
+	replace.Set(reflect.Zero(replace.Type()))
+	replace = replace.FieldByName("Replace")
+	replace.FieldByName("Source").Set(reflect.ValueOf(&source))
+	replace.FieldByName("Verification").FieldByName("Hash").Set(reflect.ValueOf(&hash))
+	out, err = marshalValid(pointer, opts.Pretty)
+	if err != nil {
+		return nil, fmt.Errorf("pointer config is invalid: %w", err)
+	}
+	return out, nil
+}
+
+// splitResource moves the contents of a resource to a blob if its data
+// URL is too long.
+func splitResource(resource reflect.Value, opts Options) error {
+	sourceField := resource.FieldByName("Source")
+	if sourceField.IsNil() {
+		return nil
+	}
+	source := *sourceField.Interface().(*string)
+	if !strings.HasPrefix(source, "data:") || len(source) <= opts.Threshold {
+		return nil
+	}
+	raw, err := dataurl.DecodeString(source)
+	if err != nil {
+		return err
+	}
+	var compression *string
+	if f := resource.FieldByName("Compression"); f.IsValid() {
+		compression, _ = f.Interface().(*string)
+	}
+	contents, err := baseutil.DecodeDataURL(source, compression)
+	if err != nil {
+		return err
+	}
+
+	name, err := writeBlob(opts.Dir, raw.Data, "")
+	if err != nil {
+		return err
+	}
+	url := blobURL(opts.BaseURL, name)
+	sourceField.Set(reflect.ValueOf(&url))
+	hashField := resource.FieldByName("Verification").FieldByName("Hash")
+	if hashField.IsNil() {
+		sum := sha512.Sum512(contents)
+		hash := "sha512-" + hex.EncodeToString(sum[:])
+		hashField.Set(reflect.ValueOf(&hash))
+	}
+	return nil
+}
+
+// writeBlob writes data to a file in dir named by its SHA-256 hash and the
+// specified extension, and returns the file name.
+func writeBlob(dir string, data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:]) + ext
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func blobURL(baseURL, name string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + name
+}
+
+// marshalValid validates cfg and marshals it.
+func marshalValid(cfg interface{}, pretty bool) ([]byte, error) {
+	if r := validate.Validate(cfg, "json"); r.IsFatal() {
+		return nil, errors.New(r.String())
+	}
+	if pretty {
+		return json.MarshalIndent(cfg, "", "  ")
+	}
+	return json.Marshal(cfg)
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package split
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/clarketm/json"
+	"github.com/coreos/ignition/v2/config/v3_3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	big := strings.Repeat("x", 100)
+	bigHash := fmt.Sprintf("sha512-%x", sha512.Sum512([]byte(big)))
+	bigBlob := fmt.Sprintf("%x", sha256.Sum256([]byte(big)))
+	in := `{"ignition":{"timeouts":{"httpTotal":10},"version":"3.3.0"},"storage":{"files":[{"path":"/big","contents":{"source":"data:,` + big + `"},"append":[{"source":"data:,small"},{"source":"data:,` + big + `"}]}]}}`
+
+	tests := []struct {
+		pointer bool
+		out     func(dir string) string
+	}{
+		{
+			false,
+			func(dir string) string {
+				return `{"ignition":{"timeouts":{"httpTotal":10},"version":"3.3.0"},"storage":{"files":[{"path":"/big","append":[{"source":"data:,small"},{"source":"https://example.com/blobs/` + bigBlob + `","verification":{"hash":"` + bigHash + `"}}],"contents":{"source":"https://example.com/blobs/` + bigBlob + `","verification":{"hash":"` + bigHash + `"}}}]}}`
+			},
+		},
+		{
+			true,
+			func(dir string) string {
+				matches, err := filepath.Glob(filepath.Join(dir, "*.ign"))
+				if err != nil || len(matches) != 1 {
+					t.Fatalf("bad hosted configs: %v %v", matches, err)
+				}
+				hosted, err := os.ReadFile(matches[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				var cfg types.Config
+				if err := json.Unmarshal(hosted, &cfg); err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, "https://example.com/blobs/"+bigBlob, *cfg.Storage.Files[0].Contents.Source, "bad hosted config")
+				return `{"ignition":{"config":{"replace":{"source":"https://example.com/blobs/` + filepath.Base(matches[0]) + `","verification":{"hash":"` + fmt.Sprintf("sha512-%x", sha512.Sum512(hosted)) + `"}}},"timeouts":{"httpTotal":10},"version":"3.3.0"}}`
+			},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("split %d", i), func(t *testing.T) {
+			dir := t.TempDir()
+			out, err := Split([]byte(in), Options{
+				BaseURL:   "https://example.com/blobs/",
+				Dir:       dir,
+				Threshold: 50,
+				Pointer:   test.pointer,
+			})
+			if !assert.NoError(t, err, "split failed") {
+				return
+			}
+			assert.Equal(t, test.out(dir), string(out), "bad output")
+			blob, err := os.ReadFile(filepath.Join(dir, bigBlob))
+			assert.NoError(t, err, "reading blob failed")
+			assert.Equal(t, big, string(blob), "bad blob")
+		})
+	}
+
+	_, err := Split([]byte(`{"apiVersion":"machineconfiguration.openshift.io/v1"}`), Options{})
+	assert.Equal(t, ErrNotIgnition, err, "bad error")
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+// Package watch detects changes to a set of files by polling.
+package watch
+
+import (
+	"os"
+	"time"
+)
+
+// state records a path and, if it's a symlink, the file it points to.
+type state struct {
+	link   fileState
+	target fileState
+}
+
+// fileState is the part of a file's metadata that changes when the file
+// is modified, replaced, created, or deleted.  Directory modification
+// times change when entries are added or removed.
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+}
+
+// Snapshot records the state of a set of files.
+type Snapshot map[string]state
+
+// Take records the current state of the specified files, which need not
+// exist.
+func Take(paths []string) Snapshot {
+	s := make(Snapshot, len(paths))
+	for _, p := range paths {
+		s[p] = stat(p)
+	}
+	return s
+}
+
+// Changed returns true if any of the files in the snapshot have changed
+// since it was taken.
+func (s Snapshot) Changed() bool {
+	for p, old := range s {
+		if stat(p) != old {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait blocks until any of the files in the snapshot change, checking
+// at the specified interval.
+func (s Snapshot) Wait(interval time.Duration) {
+	for !s.Changed() {
+		time.Sleep(interval)
+	}
+}
+
+func stat(p string) state {
+	return state{
+		link:   makeFileState(os.Lstat(p)),
+		target: makeFileState(os.Stat(p)),
+	}
+}
+
+func makeFileState(info os.FileInfo, err error) fileState {
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{
+		exists:  true,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		mode:    info.Mode(),
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Red Hat, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.)
+
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	tests := []struct {
+		change  func(dir string) error
+		changed bool
+	}{
+		// no change
+		{
+			func(dir string) error {
+				return nil
+			},
+			false,
+		},
+		// modified
+		{
+			func(dir string) error {
+				return ioutil.WriteFile(filepath.Join(dir, "file"), []byte("longer contents"), 0644)
+			},
+			true,
+		},
+		// deleted
+		{
+			func(dir string) error {
+				return os.Remove(filepath.Join(dir, "file"))
+			},
+			true,
+		},
+		// created
+		{
+			func(dir string) error {
+				return ioutil.WriteFile(filepath.Join(dir, "missing"), nil, 0644)
+			},
+			true,
+		},
+		// mode changed
+		{
+			func(dir string) error {
+				return os.Chmod(filepath.Join(dir, "file"), 0600)
+			},
+			true,
+		},
+		// modification time changed
+		{
+			func(dir string) error {
+				future := time.Now().Add(time.Hour)
+				return os.Chtimes(filepath.Join(dir, "file"), future, future)
+			},
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("snapshot %d", i), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("contents"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := Take([]string{filepath.Join(dir, "file"), filepath.Join(dir, "missing")})
+			assert.NoError(t, test.change(dir), "changing files failed")
+			assert.Equal(t, test.changed, s.Changed(), "bad change detection")
+		})
+	}
+}